@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Identifies the incident of this library system, so the next successful run resolves the one a failure opened
+func alertKey() string {
+	if tenantName != "" {
+		return "polarissync-" + tenantName
+	}
+	return "polarissync"
+}
+
+func alertReason() string {
+	if stats.failed() {
+		return stats.Fatal
+	}
+	return stats.Tripped
+}
+
+// Whether a run may resolve the incident of this library system. A report only run, including a sync switched to a
+// report by sources.onFailure, changed nothing, so it can't show that whatever a failed sync hit has been fixed
+func mayResolveAlert() bool {
+	return !reportOnly
+}
+
+// Trigger a pagerduty incident when the run needs attention, otherwise resolve any open one
+func sendPagerDutyEvent() error {
+	if !stats.alerting() && !mayResolveAlert() {
+		return nil
+	}
+	event := map[string]interface{}{
+		"routing_key":  config.PagerDuty.RoutingKey,
+		"event_action": "resolve",
+		"dedup_key":    alertKey(),
+	}
+	if stats.alerting() {
+		event["event_action"] = "trigger"
+		event["payload"] = map[string]interface{}{
+			"summary":  summaryTitle() + ": " + alertReason(),
+			"source":   alertKey(),
+			"severity": "error",
+			"custom_details": map[string]interface{}{
+				"runId":   runID,
+				"summary": summaryText(),
+			},
+		}
+	}
+	return postJSON("https://events.pagerduty.com/v2/enqueue", event, nil)
+}
+
+// Create an opsgenie alert when the run needs attention, otherwise close any open one
+func sendOpsgenieAlert() error {
+	base := strings.TrimRight(config.Opsgenie.Url, "/") + "/v2/alerts"
+	headers := map[string]string{"Authorization": "GenieKey " + config.Opsgenie.ApiKey}
+	if !stats.alerting() {
+		if !mayResolveAlert() {
+			return nil
+		}
+		return postJSON(base+"/"+url.PathEscape(alertKey())+"/close?identifierType=alias", map[string]string{"source": "polarissync"}, headers)
+	}
+	return postJSON(base, map[string]interface{}{
+		"message":     summaryTitle(),
+		"alias":       alertKey(),
+		"description": alertReason() + "\n\n" + summaryText(),
+		"details":     map[string]string{"runId": runID},
+		"source":      "polarissync",
+		"priority":    "P2",
+	}, headers)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The button and past tense of each approval action
+var approvalActions = map[string][2]string{"approve": {"Approve", "approved"}, "veto": {"Veto", "vetoed"}}
+
+// Whether removal emails carry approve and veto links, which needs the address of the daemon and a key to sign them
+func approvalLinksEnabled() bool {
+	return config.Approval.Url != "" && config.Approval.Key != "" && config.Removals.Delay > 0
+}
+
+// Sign an approve or veto of one computer, valid until expires, so a link can't be made up or altered
+func approvalSignature(tenant string, computer string, action string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(config.Approval.Key))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d", tenant, computer, action, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// A link to approve or veto the removal of a computer, working until the removal is due
+func approvalLink(tenant string, computer string, action string, expires time.Time) string {
+	q := url.Values{}
+	q.Set("tenant", tenant)
+	q.Set("computer", computer)
+	q.Set("action", action)
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", approvalSignature(tenant, computer, action, expires.Unix()))
+	return strings.TrimSuffix(config.Approval.Url, "/") + "/approval?" + q.Encode()
+}
+
+// The approve and veto links for every removal waiting out the delay, for the notification email
+func approvalLinksText() string {
+	pending, err := loadPending(tenantName)
+	if err != nil {
+		writeWarn("Unable to add approval links to the email: " + err.Error())
+		return ""
+	}
+	names := []string{}
+	for name, p := range pending {
+		if !p.Approved && !p.Vetoed {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("\nRemovals waiting for approval:\n")
+	for _, name := range names {
+		p := pending[name]
+		fmt.Fprintf(&b, "\n%s, due %s\n  Approve: %s\n  Veto: %s\n", p.Computer, p.Due.Local().Format("2006-01-02 15:04"),
+			approvalLink(tenantName, name, "approve", p.Due), approvalLink(tenantName, name, "veto", p.Due))
+	}
+	return b.String()
+}
+
+// Serve the approval links. Opening a link only shows a confirmation button, as mail scanners open links by
+// themselves, and the approve or veto happens when the button posts the form back
+func handleApproval(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tenant, computer, action := q.Get("tenant"), q.Get("computer"), q.Get("action")
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	labels, known := approvalActions[action]
+	valid := err == nil && known &&
+		hmac.Equal([]byte(q.Get("sig")), []byte(approvalSignature(tenant, computer, action, expires)))
+	if !valid {
+		http.Error(w, "This link is not valid", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() > expires {
+		http.Error(w, "This link has expired, the removal is already due", http.StatusGone)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method != http.MethodPost {
+		fmt.Fprintf(w, "<!DOCTYPE html><html><body><form method=\"post\"><p>%s the removal of %s from Polaris?</p>"+
+			"<button type=\"submit\">%s</button></form></body></html>", labels[0], html.EscapeString(computer), labels[0])
+		return
+	}
+	by := "approval link"
+	if r.RemoteAddr != "" {
+		by += " from " + r.RemoteAddr
+	}
+	if err := decidePending(tenant, computer, action == "approve", by); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeInfoFields(computer+" removal "+labels[1]+" by "+by,
+		logFields{"computer": computer, "action": action})
+	fmt.Fprintf(w, "<!DOCTYPE html><html><body><p>The removal of %s has been %s.</p></body></html>",
+		html.EscapeString(computer), labels[1])
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type awsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	//When temporary credentials of the instance role stop working, zero for keys that don't expire
+	Expiration time.Time
+}
+
+var awsCreds *awsCredentials
+
+// Read a secret from AWS Secrets Manager, referenced as aws-sm://name or aws-sm://name#key for a field of a JSON
+// secret such as an RDS credential
+func resolveAWSSecret(ref string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(ref, "aws-sm://"), "#", 2)
+	var resp struct {
+		SecretString string
+	}
+	if err := awsRequest("secretsmanager", "secretsmanager.GetSecretValue", map[string]interface{}{"SecretId": parts[0]}, &resp); err != nil {
+		return "", err
+	}
+	if len(parts) == 1 {
+		return resp.SecretString, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(resp.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not json: %w", parts[0], err)
+	}
+	value, ok := fields[parts[1]].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no field %s", parts[0], parts[1])
+	}
+	return value, nil
+}
+
+// Read a parameter from SSM Parameter Store, decrypting SecureString values. aws-ssm://polarissync/db/password
+// refers to the parameter /polarissync/db/password
+func resolveAWSParameter(ref string) (string, error) {
+	name := strings.TrimPrefix(ref, "aws-ssm://")
+	if strings.Contains(name, "/") && !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	var resp struct {
+		Parameter struct {
+			Value string
+		}
+	}
+	if err := awsRequest("ssm", "AmazonSSM.GetParameter", map[string]interface{}{"Name": name, "WithDecryption": true}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Parameter.Value, nil
+}
+
+// Call an AWS JSON api, signing the request with signature version 4
+func awsRequest(service string, target string, body interface{}, result interface{}) error {
+	region := config.AWS.Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return fmt.Errorf("no aws region configured")
+	}
+
+	creds, err := awsCredentialChain()
+	if err != nil {
+		return fmt.Errorf("unable to find aws credentials: %w", err)
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	host := service + "." + region + ".amazonaws.com"
+	req, err := http.NewRequest("POST", "https://"+host+"/", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	headers := map[string]string{
+		"content-type": "application/x-amz-json-1.1",
+		"host":         host,
+		"x-amz-date":   amzDate,
+		"x-amz-target": target,
+	}
+	if creds.Token != "" {
+		headers["x-amz-security-token"] = creds.Token
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+		if name != "host" {
+			req.Header.Set(name, headers[name])
+		}
+	}
+	signedHeaders := strings.Join(names, ";")
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{"POST", "/", "", canonicalHeaders, signedHeaders, hex.EncodeToString(payloadHash[:])}, "\n")
+
+	scope := now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{now.Format("20060102"), region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyId, scope, signedHeaders, signature))
+
+	return doJSONRequest(req, result)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Use keys from the config, then the standard environment variables, then the role of the EC2 instance. The
+// credentials of the role are fetched again when they are about to expire
+func awsCredentialChain() (*awsCredentials, error) {
+	if awsCreds != nil && (awsCreds.Expiration.IsZero() || time.Until(awsCreds.Expiration) > 5*time.Minute) {
+		return awsCreds, nil
+	}
+	if config.AWS.AccessKeyId != "" {
+		awsCreds = &awsCredentials{AccessKeyId: config.AWS.AccessKeyId, SecretAccessKey: config.AWS.SecretAccessKey}
+	} else if os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		awsCreds = &awsCredentials{AccessKeyId: os.Getenv("AWS_ACCESS_KEY_ID"), SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), Token: os.Getenv("AWS_SESSION_TOKEN")}
+	} else {
+		creds, err := awsInstanceCredentials()
+		if err != nil {
+			return nil, err
+		}
+		awsCreds = creds
+	}
+	return awsCreds, nil
+}
+
+// Read the temporary credentials of the instance role from the metadata service (IMDSv2)
+func awsInstanceCredentials() (*awsCredentials, error) {
+	client := http.Client{Timeout: 5 * time.Second}
+	metadata := func(method string, path string, header string, value string) (string, error) {
+		req, err := http.NewRequest(method, "http://169.254.169.254/latest/"+path, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set(header, value)
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("instance metadata returned %s", resp.Status)
+		}
+		data, err := io.ReadAll(resp.Body)
+		return strings.TrimSpace(string(data)), err
+	}
+
+	token, err := metadata("PUT", "api/token", "X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	if err != nil {
+		return nil, err
+	}
+	role, err := metadata("GET", "meta-data/iam/security-credentials/", "X-aws-ec2-metadata-token", token)
+	if err != nil {
+		return nil, err
+	}
+	data, err := metadata("GET", "meta-data/iam/security-credentials/"+strings.SplitN(role, "\n", 2)[0], "X-aws-ec2-metadata-token", token)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds awsCredentials
+	if err = json.Unmarshal([]byte(data), &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
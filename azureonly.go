@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/venutios/polarissync/sources/azure"
+)
+
+// How Azure AD reports each way a device can be joined
+var joinTypes = map[string]string{
+	"AzureAd":   "Azure AD joined",
+	"ServerAd":  "Hybrid joined",
+	"Workplace": "Registered",
+}
+
+// List the devices in Azure AD that are neither in AD nor in Polaris, by how they were joined. Devices registered
+// by their users rather than joined are usually personal ones that never belonged in the device list
+func runAzureOnlyCommand(args []string) int {
+	fs := flag.NewFlagSet("azure-only", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	fs.StringVar(&outputFormat, "output-format", "text", "print the results as text, csv or json")
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if outputFormat != "text" && outputFormat != "csv" && outputFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %s\n", outputFormat)
+		return exitUsage
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	if *cf.tenant == "" && len(config.Tenants) > 0 {
+		fmt.Fprintln(os.Stderr, "Select the tenant to list with -tenant")
+		return exitUsage
+	}
+
+	directory, err := openDirectory("azure.backend", config.Azure.Backend, config.Azure.Fixture)
+	if err != nil {
+		return exitWithError(err)
+	}
+	d, ok := directory.(azure.Directory)
+	if !ok {
+		return exitWithError(fmt.Errorf("listing every Azure AD device needs the powershell backend"))
+	}
+	if err = listDBComputers(); err != nil {
+		return exitWithError(err)
+	}
+	if config.ActiveDirectory.Enabled {
+		if err = listADComputers(); err != nil {
+			return exitWithError(err)
+		}
+	}
+	writeInfo("Loading every device from Azure AD")
+	devices, err := azure.AllDevices(d.Options)
+	if err != nil {
+		return exitWithError(err)
+	}
+	devices = azureOnlyDevices(devices)
+
+	switch outputFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(devices)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"name", "joinType", "profileType", "operatingSystem", "enabled", "lastLogon", "deviceId"})
+		for _, device := range devices {
+			w.Write([]string{device.Name, joinType(device.TrustType), device.ProfileType, device.OSType, device.Enabled,
+				device.LastLogon, device.DeviceID})
+		}
+		w.Flush()
+	default:
+		byType := map[string][]azure.Device{}
+		types := []string{}
+		for _, device := range devices {
+			t := joinType(device.TrustType)
+			if _, ok := byType[t]; !ok {
+				types = append(types, t)
+			}
+			byType[t] = append(byType[t], device)
+		}
+		sort.Strings(types)
+		fmt.Printf("In Azure AD only (%d)\n", len(devices))
+		for _, t := range types {
+			fmt.Printf("\n%s (%d)\n", t, len(byType[t]))
+			for _, device := range byType[t] {
+				fmt.Printf("  %-20s %-12s %-10s last logon %s\n", device.Name, device.OSType, enabledText(device.Enabled), device.LastLogon)
+			}
+		}
+	}
+	return 0
+}
+
+// The devices found in neither Polaris nor AD, compared the same way as a sync, sorted by name
+func azureOnlyDevices(devices []azure.Device) []azure.Device {
+	only := []azure.Device{}
+	for _, device := range devices {
+		known := false
+		for _, name := range matcher.Add(device.Name) {
+			if len(computerSources[name]) > 0 {
+				known = true
+			}
+		}
+		if !known && strings.TrimSpace(device.Name) != "" {
+			only = append(only, device)
+		}
+	}
+	sort.Slice(only, func(i, j int) bool { return strings.ToUpper(only[i].Name) < strings.ToUpper(only[j].Name) })
+	return only
+}
+
+// A readable name for a device trust type
+func joinType(trustType string) string {
+	if t, ok := joinTypes[trustType]; ok {
+		return t
+	}
+	if trustType == "" {
+		return "Unknown"
+	}
+	return trustType
+}
+
+func enabledText(enabled string) string {
+	if strings.EqualFold(enabled, "false") {
+		return "disabled"
+	}
+	return "enabled"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/venutios/polarissync/fixture"
+	"github.com/venutios/polarissync/polarisdb"
+	"github.com/venutios/polarissync/sources/ad"
+	"github.com/venutios/polarissync/sources/azure"
+	"github.com/venutios/polarissync/syncengine"
+)
+
+// Implementations of the Polaris database, keyed by the name used in database.backend. write selects the
+// connection used to change workstations rather than read them
+var databaseBackends = map[string]func(write bool) (syncengine.Database, error){
+	"sql": func(write bool) (syncengine.Database, error) {
+		conn := readConnString()
+		if write {
+			conn = writeConnString()
+		}
+		db, err := polarisdb.OpenSQL(conn)
+		if err != nil {
+			return nil, err
+		}
+		db.Column = config.Database.KeyColumn
+		return db, nil
+	},
+	"fixture": func(write bool) (syncengine.Database, error) {
+		return fixture.Database{File: configRelativePath(config.Database.Fixture)}, nil
+	},
+}
+
+// Implementations of the directory sources, keyed by the name used in activedirectory.backend and azure.backend.
+// fixture is the file given for the source, e.g. activedirectory.fixture
+var directoryBackends = map[string]func(fixtureFile string) syncengine.Directory{
+	"ldap": func(string) syncengine.Directory {
+		return ad.Directory{Options: adOptions()}
+	},
+	"powershell": func(string) syncengine.Directory {
+		return azure.Directory{Options: azureOptions()}
+	},
+	"fixture": func(fixtureFile string) syncengine.Directory {
+		return fixture.Directory{File: configRelativePath(fixtureFile)}
+	},
+}
+
+// The time used for the run and its decisions
+var clock syncengine.Clock = syncengine.SystemClock{}
+
+// Open the database with the backend from the config. A simulation reads from it but never writes
+func openDatabase(write bool) (syncengine.Database, error) {
+	open, ok := databaseBackends[strings.ToLower(config.Database.Backend)]
+	if !ok {
+		return nil, fmt.Errorf("unknown database.backend %s", config.Database.Backend)
+	}
+	db, err := open(write && !simulate)
+	if err != nil || !simulate {
+		return db, err
+	}
+	return dryDatabase{db}, nil
+}
+
+// The directory backend named in the config for a source, such as activedirectory.backend
+func openDirectory(key string, backend string, fixtureFile string) (syncengine.Directory, error) {
+	open, ok := directoryBackends[strings.ToLower(backend)]
+	if !ok {
+		return nil, fmt.Errorf("unknown %s %s", key, backend)
+	}
+	return open(fixtureFile), nil
+}
+
+// A database whose changes are logged and thrown away, for simulations against a live system
+type dryDatabase struct {
+	syncengine.Database
+}
+
+func (d dryDatabase) DeleteWorkstation(name string) error {
+	writeDebug("Simulated delete of " + name)
+	return nil
+}
+
+func (d dryDatabase) RenameWorkstation(oldName string, newName string) error {
+	writeDebug("Simulated rename of " + oldName + " to " + newName)
+	return nil
+}
+
+func (d dryDatabase) AddWorkstation(orgID int, name string) (int64, error) {
+	writeDebug("Simulated add of " + name)
+	return 0, nil
+}
+
+func (d dryDatabase) AddToGroup(groupID int, workstationID int64) error {
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/venutios/polarissync/polarisdb"
+)
+
+// A backup of the workstations about to be removed, enough to put them back with the restore command or by hand
+type workstationBackupFile struct {
+	RunId        string                        `json:"runId"`
+	Tenant       string                        `json:"tenant,omitempty"`
+	Time         time.Time                     `json:"time"`
+	Workstations []polarisdb.WorkstationBackup `json:"workstations"`
+}
+
+// Save every column of the workstations about to be removed, and the rows referring to them, to a new file named
+// from backup.file. Nothing is removed unless the backup is saved
+func backupWorkstations(names []string) error {
+	if config.Backup.File == "" || simulate || len(names) == 0 {
+		return nil
+	}
+	defer startSpan("back up workstations").finish()
+	db, err := openDatabase(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	backup := workstationBackupFile{RunId: runID, Tenant: tenantName, Time: clock.Now()}
+	if sqlDB, ok := db.(*polarisdb.SQL); ok {
+		err = withRetry("Backing up workstations", func() (err error) {
+			backup.Workstations, err = sqlDB.Backup(names)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("unable to back up the workstations to remove: %w", err)
+		}
+	} else {
+		//Other backends hold nothing but the names
+		for _, name := range names {
+			backup.Workstations = append(backup.Workstations, polarisdb.WorkstationBackup{Name: name})
+		}
+	}
+
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := configRelativePath(logFileName(config.Backup.File, tenantName, backup.Time))
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to save the backup of the workstations to remove: %w", err)
+	}
+	//A run never overwrites an earlier backup, one taken in the same second gets the run id added to its name
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, os.ErrExist) {
+		path = strings.TrimSuffix(path, filepath.Ext(path)) + "-" + runID + filepath.Ext(path)
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to save the backup of the workstations to remove: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to save the backup of the workstations to remove: %w", err)
+	}
+	writeInfoFields(fmt.Sprintf("Backed up %d workstations to %s", len(names), path), logFields{"count": len(names), "file": path})
+	return nil
+}
+
+// Read a backup written before a removal. Numbers are kept as written, so a bigint keeps every digit
+func readBackupFile(path string) (workstationBackupFile, error) {
+	var backup workstationBackupFile
+	f, err := os.Open(path)
+	if err != nil {
+		return backup, fmt.Errorf("unable to read the backup: %w", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err = dec.Decode(&backup); err != nil {
+		return backup, fmt.Errorf("%s is not a valid backup: %w", path, err)
+	}
+	return backup, nil
+}
+
+// Put the workstations of a backup back into Polaris, every one or only those named. Each workstation is restored
+// on its own, so one that fails leaves the others. Returns the names restored
+func restoreWorkstations(backup workstationBackupFile, names []string) ([]string, error) {
+	selected := nameRules().NameSet(names)
+	db, err := openDatabase(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	sqlDB, ok := db.(*polarisdb.SQL)
+	if !ok {
+		return nil, fmt.Errorf("the %s backend can't be restored to", config.Database.Backend)
+	}
+
+	restored, failed := []string{}, []string{}
+	for _, b := range backup.Workstations {
+		if len(names) > 0 && !selected[normalize(b.Name)] {
+			continue
+		}
+		if err := sqlDB.Restore(b); err != nil {
+			failed = append(failed, b.Name)
+			writeWarnFields("Failed to restore "+b.Name+": "+err.Error(), logFields{"computer": b.Name, "action": "restore", "error": err.Error()})
+			continue
+		}
+		restored = append(restored, b.Name)
+		writeInfoFields(b.Name+" restored from the backup of run "+backup.RunId, logFields{"computer": b.Name, "action": "restore"})
+	}
+	if len(failed) > 0 {
+		return restored, fmt.Errorf("unable to restore %s", strings.Join(failed, ", "))
+	}
+	return restored, nil
+}
+
+// Put workstations removed by a run back into Polaris from the backup saved before the removal
+func runRestoreCommand(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	list := fs.Bool("list", false, "list the workstations in the backup without restoring them")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: polarissync restore [flags] <backup file> [computer...]")
+		fmt.Fprintln(os.Stderr, "Restores every workstation in the backup, or only the computers named")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	backup, err := readBackupFile(fs.Arg(0))
+	if err != nil {
+		return exitWithError(err)
+	}
+
+	if *list {
+		fmt.Printf("Backup taken by run %s at %s\n", backup.RunId, backup.Time.Local().Format("2006-01-02 15:04:05"))
+		for _, b := range backup.Workstations {
+			related := 0
+			for _, c := range b.Children {
+				related += len(c.Rows)
+			}
+			fmt.Printf("  %-20s %d related rows\n", b.Name, related)
+		}
+		return 0
+	}
+
+	restored, err := restoreWorkstations(backup, fs.Args()[1:])
+	if len(restored) > 0 {
+		fmt.Printf("Restored %d workstations\n", len(restored))
+		fmt.Println("A restored computer that is still missing from the directory will be removed again by the next sync unless it is exempt")
+	}
+	if err != nil {
+		writeError(err)
+		return exitFatal
+	}
+	return 0
+}
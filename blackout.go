@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A time of day when the daemon must not start a sync, on the days it starts
+type blackoutWindow struct {
+	text       string
+	days       uint64
+	start, end int
+}
+
+// Parse windows such as "09:00-18:00" for every day or "Mon-Sat 09:00-18:00". A window ending before it starts,
+// such as "Sun 22:00-02:00", runs past midnight into the next day
+func parseBlackouts(windows []string) ([]blackoutWindow, error) {
+	parsed := []blackoutWindow{}
+	for _, text := range windows {
+		w := blackoutWindow{text: strings.TrimSpace(text), days: 1<<7 - 1}
+		fields := strings.Fields(w.text)
+		if len(fields) == 2 {
+			days, err := parseCronField(fields[0], 0, 7, cronDays)
+			if err != nil {
+				return nil, fmt.Errorf("blackout window %q has invalid days: %v", text, err)
+			}
+			//7 is also sunday
+			if days&(1<<7) != 0 {
+				days |= 1
+			}
+			w.days = days
+			fields = fields[1:]
+		}
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("blackout window %q should look like Mon-Fri 09:00-18:00", text)
+		}
+		times := strings.SplitN(fields[0], "-", 2)
+		var err error
+		if len(times) != 2 {
+			return nil, fmt.Errorf("blackout window %q needs a start and end time", text)
+		}
+		if w.start, err = parseTimeOfDay(times[0]); err != nil {
+			return nil, fmt.Errorf("blackout window %q: %v", text, err)
+		}
+		if w.end, err = parseTimeOfDay(times[1]); err != nil {
+			return nil, fmt.Errorf("blackout window %q: %v", text, err)
+		}
+		parsed = append(parsed, w)
+	}
+	return parsed, nil
+}
+
+// Minutes since midnight of a time like 09:30
+func parseTimeOfDay(s string) (int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// When the window covering t ends, or the zero time when t is outside it
+func (w blackoutWindow) endAfter(t time.Time) time.Time {
+	//A window past midnight may have started the day before
+	for back := 0; back <= 1; back++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()-back, 0, 0, 0, 0, t.Location())
+		if w.days&(1<<uint(day.Weekday())) == 0 {
+			continue
+		}
+		start := day.Add(time.Duration(w.start) * time.Minute)
+		end := day.Add(time.Duration(w.end) * time.Minute)
+		if w.end <= w.start {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return end
+		}
+	}
+	return time.Time{}
+}
+
+// When the blackout covering t ends, following windows that overlap or touch, or the zero time when a sync can
+// start at t. Also returns the window that applies
+func blackoutEnd(windows []blackoutWindow, t time.Time) (time.Time, string) {
+	end, text := time.Time{}, ""
+	for i := 0; i < 2*len(windows); i++ {
+		found := false
+		for _, w := range windows {
+			if e := w.endAfter(t); !e.IsZero() {
+				if text == "" {
+					text = w.text
+				}
+				end, t, found = e, e, true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return end, text
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/venutios/polarissync/polarisdb"
+	"github.com/venutios/polarissync/sources/ad"
+)
+
+// Checks made before removing computers, in order. Each is given the computers about to be removed and returns
+// those that should be kept for review instead, with the reason
+var removalChecks = []struct {
+	name    string
+	enabled func() bool
+	check   func(names []string) (map[string]string, error)
+}{
+	{"recycle bin", func() bool {
+		//Only a real directory has a recycle bin, a fixture doesn't
+		return config.Checks.RecycleBin && config.ActiveDirectory.Enabled && strings.EqualFold(config.ActiveDirectory.Backend, "ldap")
+	}, checkRecycleBin},
+	{"dns", func() bool { return config.Checks.Dns }, checkDNS},
+	{"liveness", func() bool { return config.Checks.Ping || len(config.Checks.Ports) > 0 }, checkLiveness},
+	{"winrm", func() bool { return config.Checks.WinRM }, checkWinRM},
+	{"polaris sessions", func() bool {
+		//Sessions are only known to the real database
+		return config.Checks.Sessions && strings.EqualFold(config.Database.Backend, "sql")
+	}, checkSessions},
+	{"polaris activity", func() bool {
+		return config.Checks.ActivityWindow > 0 && strings.EqualFold(config.Database.Backend, "sql")
+	}, checkActivity},
+}
+
+// Run the enabled removal checks over the orphans, returning the computers that passed them all. Those held back
+// are recorded as deferred. A check that fails holds back everything it was given, it is safer to remove nothing
+// than to remove a live computer
+func applyRemovalChecks(names []string) []string {
+	for _, c := range removalChecks {
+		if len(names) == 0 || !c.enabled() {
+			continue
+		}
+		span := startSpan("check "+c.name, "count", strconv.Itoa(len(names)))
+		held, err := c.check(names)
+		if err != nil {
+			span.fail(err.Error())
+			stats.addError(fmt.Sprintf("The %s check failed: %s", c.name, err.Error()))
+			writeWarnFields("The "+c.name+" check failed, not removing any computers: "+err.Error(), logFields{"check": c.name, "error": err.Error()})
+			held = map[string]string{}
+			for _, name := range names {
+				held[name] = "the " + c.name + " check failed"
+			}
+		} else {
+			checksPassed = append(checksPassed, c.name)
+		}
+		span.finish()
+
+		passed := []string{}
+		for _, name := range names {
+			reason, ok := held[name]
+			if !ok {
+				passed = append(passed, name)
+				continue
+			}
+			stats.Deferred = append(stats.Deferred, name)
+			recordDecision(name, "defer", reason)
+			writeInfoFields("Keeping "+name+" for review, "+reason, logFields{"computer": name, "action": "defer", "check": c.name})
+		}
+		names = passed
+	}
+	sort.Strings(stats.Deferred)
+	return names
+}
+
+// Hold back the computers that aren't in the AD recycle bin. A computer deleted from AD is moved there, one missing
+// from the search base but not deleted was probably moved to another OU
+func checkRecycleBin(names []string) (map[string]string, error) {
+	deleted := map[string]bool{}
+	_, err := ad.DeletedComputers(adOptions(), func(name string, parent string) {
+		deleted[normalize(name)] = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	held := map[string]string{}
+	for _, name := range names {
+		if !deleted[normalize(name)] {
+			held[name] = "not in the AD recycle bin, it may have been moved rather than deleted"
+		}
+	}
+	return held, nil
+}
+
+// Run check for each computer, several at once as most checks spend their time waiting on the network, and collect
+// the computers it holds back
+func checkConcurrently(names []string, check func(name string) string) map[string]string {
+	held := map[string]string{}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+	for i := 0; i < checkWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				if reason := check(name); reason != "" {
+					lock.Lock()
+					held[name] = reason
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for _, name := range names {
+		jobs <- name
+	}
+	close(jobs)
+	wg.Wait()
+	return held
+}
+
+// How many computers are checked at once
+const checkWorkers = 16
+
+// The host name to check for a computer, with checks.dnsDomain appended to names without a dot when it is set
+func checkHost(name string) string {
+	if config.Checks.DnsDomain != "" && !strings.Contains(name, ".") {
+		return name + "." + strings.TrimPrefix(config.Checks.DnsDomain, ".")
+	}
+	return name
+}
+
+// Hold back the computers whose names still resolve in DNS, a live machine that fell out of the directory for a
+// while usually still has its record
+func checkDNS(names []string) (map[string]string, error) {
+	return checkConcurrently(names, func(name string) string {
+		host := checkHost(name)
+		ctx, cancel := context.WithTimeout(context.Background(), config.Checks.Timeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		var dnsErr *net.DNSError
+		switch {
+		case err == nil:
+			return "still resolves in DNS to " + strings.Join(addrs, ", ")
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+			return ""
+		}
+		//Without an answer there is no telling whether the machine is still around
+		return "the DNS lookup failed: " + err.Error()
+	}), nil
+}
+
+// Hold back the computers that answer a ping or accept a connection on one of checks.ports, such as 3389 or 135
+func checkLiveness(names []string) (map[string]string, error) {
+	return checkConcurrently(names, func(name string) string {
+		host := checkHost(name)
+		if config.Checks.Ping && ping(host, config.Checks.Timeout) {
+			return "answers ping"
+		}
+		for _, port := range config.Checks.Ports {
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), config.Checks.Timeout)
+			if err == nil {
+				conn.Close()
+				return "accepts connections on port " + strconv.Itoa(port)
+			}
+		}
+		return ""
+	}), nil
+}
+
+// Hold back the workstations with a staff client signed in, removing one would break the session in use at a desk
+func checkSessions(names []string) (map[string]string, error) {
+	return heldByDatabase(names, "has a Polaris session open", func(db *polarisdb.SQL) ([]string, error) {
+		return db.SignedIn(config.Checks.SessionsQuery)
+	})
+}
+
+// Hold back the workstations with transactions within checks.activityWindow, a workstation that checked items out
+// yesterday is in use whatever the directory says
+func checkActivity(names []string) (map[string]string, error) {
+	since := clock.Now().Add(-config.Checks.ActivityWindow)
+	return heldByDatabase(names, "has Polaris transactions since "+since.Format("2006-01-02 15:04"), func(db *polarisdb.SQL) ([]string, error) {
+		return db.ActiveSince(since)
+	})
+}
+
+// Hold back the computers among names that a query of the Polaris database returns, with the reason
+func heldByDatabase(names []string, reason string, query func(db *polarisdb.SQL) ([]string, error)) (map[string]string, error) {
+	db, err := openDatabase(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	//Reading is safe in a simulation
+	if dry, ok := db.(dryDatabase); ok {
+		db = dry.Database
+	}
+	sqlDB, ok := db.(*polarisdb.SQL)
+	if !ok {
+		return nil, fmt.Errorf("the %s backend can't be queried", config.Database.Backend)
+	}
+	var found []string
+	err = withRetry("Querying the database", func() (err error) {
+		found, err = query(sqlDB)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	active := nameRules().NameSet(found)
+	held := map[string]string{}
+	for _, name := range names {
+		if active[normalize(name)] {
+			held[name] = reason
+		}
+	}
+	return held, nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"sync", "Remove orphaned workstations from Polaris and add new ones (the default)", runSyncCommand},
+		{"daemon", "Stay running and sync on the schedule in the config", runDaemonCommand},
+		{"service", "Install, uninstall, start or stop the Windows service running the daemon", runServiceCommand},
+		{"schedule", "Create, update or remove the Windows scheduled task running the sync", runScheduleCommand},
+		{"report", "List the workstations a sync would remove or add without changing the database", runReportCommand},
+		{"simulate", "Rehearse a sync, e.g. against fixture files, without changing the database or sending anything", runSimulateCommand},
+		{"restore", "Put removed workstations back into Polaris from the backup saved before they were removed", runRestoreCommand},
+		{"renames", "List, add or remove the renames kept in the run history, old name to new name", runRenamesCommand},
+		{"approval", "List, approve or veto the removals waiting out removals.delay", runApprovalCommand},
+		{"lookup", "Show what Polaris and the directories hold about a computer", runLookupCommand},
+		{"explain", "Show how a sync would treat a computer and why", runExplainCommand},
+		{"diff", "List the computers only in Polaris, only in the directory and in both", runDiffCommand},
+		{"unregistered", "List the directory computers with no Polaris workstation, for registering new machines", runUnregisteredCommand},
+		{"azure-only", "List the Azure AD devices in neither AD nor Polaris by join type, e.g. personal registrations", runAzureOnlyCommand},
+		{"history", "Show trends and anomalies from the run history", runHistoryCommand},
+		{"diff-runs", "Compare the computers seen by two runs in the history, by default the last two", runDiffRunsCommand},
+		{"validate", "Check the config file and the connection to each system", runValidateCommand},
+		{"init", "Create a config file interactively", runInitCommand},
+		{"protect", "Encrypt the passwords in the config file with DPAPI (Windows)", runProtectCommand},
+		{"encrypt-config", "Encrypt the passwords in the config file with the master key", runEncryptConfigCommand},
+		{"decrypt-config", "Decrypt the passwords in the config file encrypted with the master key", runDecryptConfigCommand},
+		{"version", "Show the version of this build", runVersionCommand},
+		{"help", "Show this list of commands", runHelpCommand},
+	}
+}
+
+// Run the command named by the first argument. Without a command, or when the first argument is a flag, the sync is
+// run so existing scheduled tasks keep working
+func runCommand(args []string) (code int) {
+	//Errors are returned, so a panic is a bug. It still exits with the fatal code rather than the 2 of an
+	//uncaught panic
+	defer func() {
+		if r := recover(); r != nil {
+			code = exitFatal
+			fmt.Fprintf(os.Stderr, "%s\n%s", redact(fmt.Sprint(r)), debug.Stack())
+		}
+	}()
+
+	name := "sync"
+	if len(args) > 0 && (args[0] == "-version" || args[0] == "--version") {
+		return runVersionCommand(nil)
+	}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	}
+
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %s\n\n", name)
+	runHelpCommand(nil)
+	return exitUsage
+}
+
+func runHelpCommand(args []string) int {
+	fmt.Println("Usage: polarissync <command> [flags]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, c := range commands {
+		fmt.Printf("  %-16s %s\n", c.name, c.summary)
+	}
+	fmt.Println()
+	fmt.Println("Run polarissync <command> -h for the flags of a command")
+	return 0
+}
+
+// The exit code for flags that failed to parse. The flag package has already printed the problem and the usage, -h
+// only asked for the usage
+func flagsExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitSuccess
+	}
+	return exitUsage
+}
+
+// Flags for finding and loading the config, shared by the commands that need it
+type configFlags struct {
+	file    *string
+	profile *string
+	tenant  *string
+	prompt  *bool
+	verbose *bool
+}
+
+func addConfigFlags(fs *flag.FlagSet) configFlags {
+	return configFlags{
+		file:    fs.String("config", "", "path to the config file"),
+		profile: fs.String("profile", "", "name of the profile in the config file to apply"),
+		tenant:  fs.String("tenant", "", "name of a single tenant from the config file to use"),
+		prompt:  fs.Bool("prompt-credentials", false, "ask for passwords that aren't in the config"),
+		verbose: fs.Bool("verbose", false, "log at the debug level"),
+	}
+}
+
+// Load the config, ask for any missing passwords if requested and start logging
+func (f configFlags) load() error {
+	runSpan = startSpan("polarissync")
+	configSpan := startSpan("load config")
+	err := loadConfig(*f.file, *f.profile, *f.tenant)
+	tenantName = *f.tenant
+	configSpan.finish()
+	if err != nil {
+		return err
+	}
+
+	if *f.prompt {
+		//Each tenant runs in its own process without a console to prompt in
+		if *f.tenant == "" && len(config.Tenants) > 0 {
+			return fmt.Errorf("-prompt-credentials can't be used with all tenants, select one with -tenant")
+		}
+		if err = promptCredentials(); err != nil {
+			return err
+		}
+	}
+
+	return setupLogging(*f.tenant, *f.verbose)
+}
+
+// Report an error that stopped a command before logging was set up, returning the fatal exit code
+func exitWithError(err error) int {
+	fmt.Fprintln(os.Stderr, redact(err.Error()))
+	return exitFatal
+}
+
+func runSyncCommand(args []string) int {
+	return runSyncFlags("sync", args)
+}
+
+// Run the full comparison read only. Nothing is written to the database, so the results can be given to staff
+// without admin rights
+func runReportCommand(args []string) int {
+	reportOnly = true
+	return runSyncFlags("report", args)
+}
+
+// Run every step of a sync, including the safety limits and the removals, against a database that is never
+// changed. With the fixture backends no live system is needed, so policy changes can be tried out safely
+func runSimulateCommand(args []string) int {
+	simulate = true
+	return runSyncFlags("simulate", args)
+}
+
+func runSyncFlags(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	fs.StringVar(&outputFile, "output", "", "file to write the results of the run to, e.g. results.csv")
+	fs.StringVar(&outputFormat, "output-format", "text", "print the results to stdout as text or json")
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %s\n", outputFormat)
+		return exitUsage
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+
+	//Without a tenant selected, each configured tenant is synced by its own child process
+	if *cf.tenant == "" && len(config.Tenants) > 0 {
+		return runTenants(*cf.profile)
+	}
+
+	if config.Status.Address != "" {
+		startStatusServer(config.Status.Address)
+	}
+	handleSignals(nil)
+	//The error is in the log and the run summary, the exit code tells the scheduler how it went
+	runSync()
+	if reviewWanted() {
+		writeTerminalReview(os.Stdout, useColor(os.Stdout))
+	}
+	//json results already hold the summary and keep stdout parseable
+	if outputFormat == "text" {
+		writeConsoleSummary(os.Stdout)
+	}
+	return stats.exitCode()
+}
+
+func runValidateCommand(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	return validate()
+}
+
+func runInitCommand(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	file := fs.String("config", "config.json", "path of the config file to create")
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	//There is no config to load yet
+	return runInit(*file)
+}
+
+func runProtectCommand(args []string) int {
+	fs := flag.NewFlagSet("protect", flag.ContinueOnError)
+	file := fs.String("config", "", "path to the config file")
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if err := loadConfig(*file, "", ""); err != nil {
+		return exitWithError(err)
+	}
+	return runProtect(viper.ConfigFileUsed())
+}
+
+func runEncryptConfigCommand(args []string) int {
+	fs := flag.NewFlagSet("encrypt-config", flag.ContinueOnError)
+	file := fs.String("config", "", "path to the config file")
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if err := loadConfig(*file, "", ""); err != nil {
+		return exitWithError(err)
+	}
+	return runEncryptConfig(viper.ConfigFileUsed())
+}
+
+func runDecryptConfigCommand(args []string) int {
+	fs := flag.NewFlagSet("decrypt-config", flag.ContinueOnError)
+	file := fs.String("config", "", "path to the config file")
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if err := loadConfig(*file, "", ""); err != nil {
+		return exitWithError(err)
+	}
+	return runDecryptConfig(viper.ConfigFileUsed())
+}
@@ -0,0 +1,360 @@
+// Package config holds the settings of polarissync as read from the config file, so tools embedding the sync can
+// load the same file
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// All the settings, unmarshalled from the config file by viper
+type Configuration struct {
+	ActiveDirectory struct {
+		Enabled      bool
+		Host         string
+		Trusted      bool
+		Domain       string
+		Username     string
+		Password     string
+		PasswordFile string
+		Dn           string
+		PageSize     int
+		Backend      string
+		Fixture      string
+		//The attribute compared with Polaris, e.g. dNSHostName
+		KeyAttribute     string
+		DeletedObjectsDn string
+	}
+	Azure struct {
+		Enabled bool
+		Domain  string
+		Backend string
+		Fixture string
+		//The device property compared with Polaris
+		KeyProperty string
+	}
+	Logging struct {
+		Enabled  bool
+		Location string
+		Filename string
+		Format   string
+		Level    string
+		//How often to report the progress of a long step, 0 to never
+		ProgressInterval time.Duration
+		Console          struct {
+			Enabled bool
+			Level   string
+		}
+		Syslog struct {
+			Enabled  bool
+			Network  string
+			Address  string
+			Facility string
+			Level    string
+		}
+	}
+	Database struct {
+		Host                string
+		Port                int
+		Name                string
+		Trusted             bool
+		Domain              string
+		Username            string
+		Password            string
+		PasswordFile        string
+		ExemptComputers     []string
+		ExemptComputersFile string
+		Read                DatabaseConnection
+		Write               DatabaseConnection
+		Backend             string
+		Fixture             string
+		//The column of Polaris.Workstations compared with the directory, e.g. NetworkName
+		KeyColumn string
+	}
+	Email struct {
+		Host         string
+		Port         int
+		Tls          string
+		Username     string
+		Password     string
+		From         string
+		To           []string
+		AttachReport bool
+		//A go template for the body of the mail, in place of the plain summary
+		Template     string
+		TemplateFile string
+	}
+	Teams struct {
+		WebhookUrl string
+		Notify     string
+		//A go template for the json message posted to teams, in place of the built in card
+		Template     string
+		TemplateFile string
+	}
+	//A go template for the html report, in place of the built in page. GroupBy splits the lists of computers in the
+	//reports by their Polaris branch or by the AD OU they were deleted from, so each branch can be sent its section
+	Report struct {
+		Template     string
+		TemplateFile string
+		GroupBy      string
+	}
+	Slack struct {
+		WebhookUrl      string
+		ErrorWebhookUrl string
+		Token           string
+		Channel         string
+		ErrorChannel    string
+		Notify          string
+	}
+	Webhook struct {
+		Url          string
+		Method       string
+		Headers      map[string]string
+		Template     string
+		TemplateFile string
+		Notify       string
+	}
+	PagerDuty struct {
+		RoutingKey string
+	}
+	Opsgenie struct {
+		ApiKey string
+		Url    string
+	}
+	ServiceNow struct {
+		Instance        string
+		Username        string
+		Password        string
+		Table           string
+		AssignmentGroup string
+		Fields          map[string]string
+	}
+	Sentry struct {
+		Dsn         string
+		Environment string
+	}
+	History struct {
+		File string
+		//How long runs are kept in the history, 0 keeps them for good
+		Retention time.Duration
+		//How many of the latest runs of each tenant keep the computers they saw, for diff-runs
+		SnapshotRuns int
+	}
+	//A new file holding the full rows of the workstations about to be removed, saved before each removal, e.g.
+	//backups/workstations{tenant}-%Y%m%d-%H%M%S.json
+	Backup struct {
+		File string
+	}
+	//A json lines file recording the evidence behind every removal, e.g. removals{tenant}.jsonl
+	Ledger struct {
+		File string
+	}
+	Sources struct {
+		OnFailure string
+	}
+	//The number of workstations the Polaris license allows, for reporting utilization. 0 when not tracked. The
+	//notifications are sent as an alert once the workstations use AlertPercent of the licenses, e.g. 90
+	Licenses struct {
+		Workstations int
+		AlertPercent float64
+	}
+	Removals struct {
+		Workers   int
+		PerSecond float64
+		//How long an orphan waits, announced and open to a veto, before it is removed. 0 removes straight away
+		Delay       time.Duration
+		PendingFile string
+	}
+	Retry struct {
+		Attempts   int
+		Backoff    time.Duration
+		MaxBackoff time.Duration
+		Jitter     bool
+	}
+	Lock struct {
+		File     string
+		Database bool
+	}
+	Daemon struct {
+		Schedule string
+		Jitter   time.Duration
+		CatchUp  string
+		Blackout []string
+	}
+	Status struct {
+		Address string
+	}
+	Tracing struct {
+		Endpoint string
+		Headers  map[string]string
+	}
+	Metrics struct {
+		Pushgateway       string
+		TextfileDirectory string
+		Statsd            struct {
+			Address   string
+			Prefix    string
+			Dogstatsd bool
+		}
+	}
+	Matching struct {
+		StripDnsSuffix  bool
+		NetbiosNames    bool
+		StripDiacritics bool
+	}
+	//Approve and veto links in the removal email, served by the daemon's status address. Url is the address the
+	//daemon is reached on, e.g. https://polarissync.library.local:8080, and Key signs the links
+	Approval struct {
+		Url string
+		Key string
+	}
+	//Commands run through the shell with the run context in POLARISSYNC_* variables and as json on stdin. A failing
+	//preRun stops the run, postRemove runs after each computer is removed and postRun once the run has finished
+	Hooks struct {
+		PreRun     string
+		PostRemove string
+		PostRun    string
+		Timeout    time.Duration
+	}
+	//What to do with a computer that reappears after an earlier run removed it, add it again or warn and leave it out
+	Reappearance struct {
+		Action string
+	}
+	//The names last seen for each AD objectGUID, so renames are noticed without being listed
+	Identities struct {
+		File string
+	}
+	//Computers renamed in the directory, old name to new name, whose workstations are renamed rather than removed
+	//and added again
+	Renames     map[string]string
+	RenamesFile string
+	//Policies for the computers whose names match a pattern such as STAFF-*, optionally only in one branch. The
+	//first matching rule applies
+	Rules []struct {
+		Pattern string
+		Policy  string
+		Branch  string
+	}
+	//Checks made before removing a computer, any computer failing one is kept for review instead
+	Checks struct {
+		//Only remove computers found in the AD recycle bin, so objects moved outside the search base are kept
+		RecycleBin bool
+		//Keep computers whose names still resolve, with DnsDomain appended to names without a dot
+		Dns       bool
+		DnsDomain string
+		//Keep computers that answer a ping or accept connections on one of the ports
+		Ping  bool
+		Ports []int
+		//Keep computers that answer a WinRM query, only removing those that can't be reached
+		WinRM bool
+		//Keep workstations with a staff client signed in. SessionsQuery replaces the default query for them
+		Sessions      bool
+		SessionsQuery string
+		//Keep workstations with Polaris transactions within this long, e.g. 720h for 30 days
+		ActivityWindow time.Duration
+		//How long to wait for each check of a computer
+		Timeout time.Duration
+	}
+	Safety struct {
+		MaxRemovals int
+		//Per source, either a count such as 500 or a share of the last run such as 80%
+		MinComputers map[string]string
+	}
+	Tenants []struct {
+		Name string
+	}
+	TenantsParallel bool
+	Strict          bool
+	RemoteConfig    struct {
+		Url       string
+		Sha256    string
+		CacheFile string
+	}
+	KeyVault struct {
+		TenantId     string
+		ClientId     string
+		ClientSecret string
+	}
+	Vault struct {
+		Address  string
+		Token    string
+		RoleId   string
+		SecretId string
+	}
+	SummaryFile   string
+	MasterKeyFile string
+	AWS           struct {
+		Region          string
+		AccessKeyId     string
+		SecretAccessKey string
+	}
+}
+
+// Optional overrides for the connection used to read from or write to the database. Blank values are inherited
+// from the main database settings
+type DatabaseConnection struct {
+	Host         string
+	Port         int
+	Name         string
+	Trusted      bool
+	Domain       string
+	Username     string
+	Password     string
+	PasswordFile string
+}
+
+// Register the default of every setting that has one with viper
+func SetDefaults() {
+	viper.SetDefault("logging.enabled", false)
+	viper.SetDefault("logging.location", ".")
+	viper.SetDefault("logging.filename", "polarissync{tenant}-%Y-%m-%d.log")
+	viper.SetDefault("logging.format", "text")
+	viper.SetDefault("logging.level", "info")
+	viper.SetDefault("logging.progressinterval", "10s")
+	viper.SetDefault("logging.console.enabled", true)
+	viper.SetDefault("logging.console.level", "info")
+	viper.SetDefault("logging.syslog.network", "udp")
+	viper.SetDefault("logging.syslog.address", "127.0.0.1:514")
+	viper.SetDefault("logging.syslog.facility", "local0")
+	viper.SetDefault("logging.syslog.level", "info")
+	viper.SetDefault("metrics.statsd.prefix", "polarissync.")
+	viper.SetDefault("summaryfile", "lastrun{tenant}.json")
+	viper.SetDefault("identities.file", "identities{tenant}.json")
+	viper.SetDefault("reappearance.action", "add")
+	viper.SetDefault("checks.timeout", "5s")
+	viper.SetDefault("hooks.timeout", "5m")
+	viper.SetDefault("daemon.schedule", "0 2 * * *")
+	viper.SetDefault("lock.file", "polarissync{tenant}.lock")
+	viper.SetDefault("sources.onfailure", "abort")
+	viper.SetDefault("removals.workers", 1)
+	viper.SetDefault("removals.pendingfile", "pending{tenant}.json")
+	viper.SetDefault("history.snapshotruns", 30)
+	viper.SetDefault("retry.attempts", 3)
+	viper.SetDefault("retry.backoff", "5s")
+	viper.SetDefault("retry.maxbackoff", "1m")
+	viper.SetDefault("retry.jitter", true)
+	viper.SetDefault("daemon.catchup", "run")
+	viper.SetDefault("email.port", 587)
+	viper.SetDefault("email.tls", "starttls")
+	viper.SetDefault("teams.notify", "always")
+	viper.SetDefault("slack.notify", "always")
+	viper.SetDefault("webhook.method", "POST")
+	viper.SetDefault("webhook.notify", "always")
+	viper.SetDefault("opsgenie.url", "https://api.opsgenie.com")
+	viper.SetDefault("servicenow.table", "change_request")
+	viper.SetDefault("azure.enabled", false)
+	viper.SetDefault("activedirectory.enabled", true)
+	viper.SetDefault("activedirectory.host", "127.0.0.1")
+	viper.SetDefault("activedirectory.pagesize", 500)
+	viper.SetDefault("activedirectory.backend", "ldap")
+	viper.SetDefault("activedirectory.keyattribute", "cn")
+	viper.SetDefault("azure.keyproperty", "DisplayName")
+	viper.SetDefault("database.keycolumn", "ComputerName")
+	viper.SetDefault("azure.backend", "powershell")
+	viper.SetDefault("database.backend", "sql")
+	viper.SetDefault("database.host", "127.0.0.1")
+	viper.SetDefault("database.port", 1433)
+	viper.SetDefault("database.trusted", true)
+	viper.SetDefault("database.exemptComputers", []string{})
+}
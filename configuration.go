@@ -1,30 +1,298 @@
 package main
 
-type Configuration struct {
-	ActiveDirectory struct {
-		Enabled  bool
-		Host     string
-		Domain   string
-		Username string
-		Password string
-		Dn       string
-	}
-	Azure struct {
-		Enabled bool
-		Domain  string
-	}
-	Logging struct {
-		Enabled  bool
-		Location string
-	}
-	Database struct {
-		Host            string
-		Port            int
-		Name            string
-		Trusted         bool
-		Domain          string
-		Username        string
-		Password        string
-		ExemptComputers []string
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	cfg "github.com/venutios/polarissync/config"
+)
+
+// Read the config file, apply the named profile and tenant if requested and populate the config variable
+func loadConfig(configFile string, profile string, tenant string) error {
+	//An explicit path wins, otherwise look in the working directory, next to the executable and then the standard
+	//system locations, so scheduled tasks don't depend on their start directory. The format (json, yaml or toml)
+	//is picked from the file extension
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName("config")
+		viper.AddConfigPath(".")
+		if exe, err := os.Executable(); err == nil {
+			viper.AddConfigPath(filepath.Dir(exe))
+		}
+		if programData := os.Getenv("ProgramData"); programData != "" {
+			viper.AddConfigPath(filepath.Join(programData, "polarissync"))
+		}
+		viper.AddConfigPath("/etc/polarissync")
+	}
+
+	cfg.SetDefaults()
+
+	//Every setting can be supplied as an environment variable, e.g. POLARISSYNC_DATABASE_PASSWORD
+	viper.SetEnvPrefix("polarissync")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	bindEnvKeys()
+
+	err := viper.ReadInConfig()
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %w", err)
+	}
+
+	if viper.GetString("remoteconfig.url") != "" {
+		if err = mergeRemoteConfig(); err != nil {
+			return fmt.Errorf("unable to load remote config: %w", err)
+		}
+	}
+
+	//Profiles hold partial configs (e.g. a test database and directory) that are merged over the top level settings
+	if profile != "" {
+		key := "profiles." + profile
+		if !viper.IsSet(key) {
+			return fmt.Errorf("profile %s is not defined in the config file", profile)
+		}
+		if err = viper.MergeConfigMap(viper.GetStringMap(key)); err != nil {
+			return fmt.Errorf("unable to apply profile %s: %w", profile, err)
+		}
+	}
+
+	//Tenant blocks are merged the same way as profiles, after the profile so a profile can redefine the tenants
+	if tenant != "" {
+		var tenants []map[string]interface{}
+		if err = viper.UnmarshalKey("tenants", &tenants); err != nil {
+			return fmt.Errorf("unable to read tenants: %w", err)
+		}
+		found := false
+		for _, t := range tenants {
+			if name, _ := t["name"].(string); strings.EqualFold(name, tenant) {
+				delete(t, "name")
+				if err = viper.MergeConfigMap(t); err != nil {
+					return fmt.Errorf("unable to apply tenant %s: %w", tenant, err)
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("tenant %s is not defined in the config file", tenant)
+		}
+	}
+
+	//Start from an empty struct so a reload doesn't keep list entries from the previous config
+	config = cfg.Configuration{}
+	err = viper.Unmarshal(&config)
+	if err != nil {
+		return fmt.Errorf("config file is corrupt: %w", err)
+	}
+
+	if config.Database.ExemptComputersFile != "" {
+		if err = loadExemptFile(config.Database.ExemptComputersFile); err != nil {
+			return err
+		}
+	}
+
+	if config.RenamesFile != "" {
+		if err = loadRenamesFile(config.RenamesFile); err != nil {
+			return err
+		}
+	}
+
+	//Passwords can be kept out of the config file, e.g. in a mounted docker or kubernetes secret
+	passwordFiles := []struct {
+		password *string
+		path     string
+	}{
+		{&config.Database.Password, config.Database.PasswordFile},
+		{&config.Database.Read.Password, config.Database.Read.PasswordFile},
+		{&config.Database.Write.Password, config.Database.Write.PasswordFile},
+		{&config.ActiveDirectory.Password, config.ActiveDirectory.PasswordFile},
+	}
+	for _, f := range passwordFiles {
+		if err = loadPasswordFile(f.password, f.path); err != nil {
+			return err
+		}
+	}
+	if err = resolveSecrets(); err != nil {
+		return err
+	}
+
+	if err = checkNameRules(); err != nil {
+		return err
+	}
+	if err = checkMinComputers(); err != nil {
+		return err
+	}
+	switch strings.ToLower(config.Report.GroupBy) {
+	case "", "branch", "ou":
+	default:
+		return fmt.Errorf("unknown report.groupBy %q, use branch or ou", config.Report.GroupBy)
+	}
+
+	//A misspelled key would otherwise be ignored and its default used, e.g. an exemption list that silently doesn't apply
+	if config.Strict {
+		if unknown := unknownConfigKeys(); len(unknown) > 0 {
+			return fmt.Errorf("unknown keys in config file: %s", strings.Join(unknown, ", "))
+		}
+	}
+	return nil
+}
+
+// Add the computers listed in an exemption file, one name per line with # starting a comment. A relative path is
+// taken from the folder holding the config file
+func loadExemptFile(path string) error {
+	data, err := os.ReadFile(configRelativePath(path))
+	if err != nil {
+		return fmt.Errorf("unable to read exemption file: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			config.Database.ExemptComputers = append(config.Database.ExemptComputers, strings.ToUpper(line))
+		}
+	}
+	return nil
+}
+
+// Add the renames listed in a file, the old and new names on each line separated by spaces, with # starting a
+// comment. A relative path is taken from the folder holding the config file
+func loadRenamesFile(path string) error {
+	data, err := os.ReadFile(configRelativePath(path))
+	if err != nil {
+		return fmt.Errorf("unable to read renames file: %w", err)
+	}
+	if config.Renames == nil {
+		config.Renames = map[string]string{}
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if j := strings.Index(line, "#"); j >= 0 {
+			line = line[:j]
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+		case 2:
+			config.Renames[fields[0]] = fields[1]
+		default:
+			return fmt.Errorf("line %d of the renames file should hold the old and new names", i+1)
+		}
+	}
+	return nil
+}
+
+// Replace a password with the contents of a secret file, if one is configured
+func loadPasswordFile(password *string, path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(configRelativePath(path))
+	if err != nil {
+		return fmt.Errorf("unable to read password file: %w", err)
+	}
+	//Secret files usually end with a newline that isn't part of the password
+	*password = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+// Resolve a path from the config relative to the folder holding the config file
+func configRelativePath(path string) string {
+	if !filepath.IsAbs(path) && viper.ConfigFileUsed() != "" {
+		return filepath.Join(filepath.Dir(viper.ConfigFileUsed()), path)
+	}
+	return path
+}
+
+// Register every key of the Configuration struct with viper. AutomaticEnv only applies to keys viper already knows
+// about, so without this a value set only in the environment would be ignored by Unmarshal
+func bindEnvKeys() {
+	keys := map[string]bool{}
+	knownConfigKeys(reflect.TypeOf(config), "", keys)
+	for key := range keys {
+		//Lists of blocks such as tenants and free form maps can't be expressed as a single variable
+		if key != "tenants" && key != "rules" && !strings.HasSuffix(key, ".*") {
+			viper.BindEnv(key)
+		}
+	}
+}
+
+// Collect the keys of the Configuration struct in the lower case form viper uses. Maps accept any key and are
+// recorded as key.*
+func knownConfigKeys(t reflect.Type, prefix string, keys map[string]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + strings.ToLower(field.Name)
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			knownConfigKeys(field.Type, key+".", keys)
+		case reflect.Map:
+			keys[key+".*"] = true
+		default:
+			keys[key] = true
+		}
+	}
+}
+
+// List the keys in the config file that don't match any setting, usually a typo
+func unknownConfigKeys() []string {
+	known := map[string]bool{}
+	knownConfigKeys(reflect.TypeOf(config), "", known)
+
+	unknown := []string{}
+	check := func(key string, prefix string) {
+		if known[key] {
+			return
+		}
+		for i := strings.LastIndex(key, "."); i > 0; i = strings.LastIndex(key[:i], ".") {
+			if known[key[:i]+".*"] {
+				return
+			}
+		}
+		unknown = append(unknown, prefix+key)
+	}
+
+	for _, key := range viper.AllKeys() {
+		//Keys inside a profile are checked as though they were at the top level
+		if strings.HasPrefix(key, "profiles.") {
+			parts := strings.SplitN(key, ".", 3)
+			if len(parts) == 3 {
+				check(parts[2], parts[0]+"."+parts[1]+".")
+				continue
+			}
+		}
+		check(key, "")
+	}
+
+	var tenants []map[string]interface{}
+	if err := viper.UnmarshalKey("tenants", &tenants); err == nil {
+		for i, t := range tenants {
+			for _, key := range flattenKeys(t, "") {
+				if key != "name" {
+					check(key, fmt.Sprintf("tenants[%d].", i))
+				}
+			}
+		}
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
+
+// Flatten a nested map into dotted lower case keys
+func flattenKeys(m map[string]interface{}, prefix string) []string {
+	keys := []string{}
+	for k, v := range m {
+		key := prefix + strings.ToLower(k)
+		if child, ok := v.(map[string]interface{}); ok {
+			keys = append(keys, flattenKeys(child, key+".")...)
+		} else {
+			keys = append(keys, key)
+		}
 	}
+	return keys
 }
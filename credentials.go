@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// Read a password from the console without echoing it
+func promptPassword(label string) (string, error) {
+	fmt.Fprint(os.Stderr, label+": ")
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("unable to read password: %w", err)
+	}
+	return string(password), nil
+}
+
+// Ask for each password the config needs but doesn't contain, for admins who don't want to store them anywhere
+func promptCredentials() error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("credentials can only be prompted for when running in a console")
+	}
+
+	var err error
+	ask := func(label string, username string, password *string) {
+		if *password == "" && err == nil {
+			*password, err = promptPassword(fmt.Sprintf("%s password for %s", label, username))
+		}
+	}
+
+	if !config.Database.Trusted {
+		ask("Database", config.Database.Username, &config.Database.Password)
+	}
+	if config.Database.Read.Username != "" && !config.Database.Read.Trusted {
+		ask("Database read", config.Database.Read.Username, &config.Database.Read.Password)
+	}
+	if config.Database.Write.Username != "" && !config.Database.Write.Trusted {
+		ask("Database write", config.Database.Write.Username, &config.Database.Write.Password)
+	}
+	if (config.ActiveDirectory.Enabled && !config.ActiveDirectory.Trusted) || config.Azure.Enabled {
+		ask("Active Directory", config.ActiveDirectory.Username, &config.ActiveDirectory.Password)
+	}
+	return err
+}
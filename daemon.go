@@ -0,0 +1,233 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/venutios/polarissync/syncengine"
+)
+
+// A scheduled time this long in the past when it is noticed, e.g. after the machine was asleep or a run overran,
+// counts as missed and is handled by the catch up policy
+const missedRunGrace = 2 * time.Minute
+
+var (
+	//Closed to stop the daemon once the run in progress, if any, has finished
+	stopDaemon = make(chan struct{})
+	stopOnce   sync.Once
+)
+
+// Ask the daemon to stop, from a signal or the service manager
+func requestDaemonStop() {
+	stopOnce.Do(func() {
+		close(stopDaemon)
+	})
+}
+
+func runDaemonCommand(args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	return runDaemon(cf)
+}
+
+// Run the sync on the schedule from the config until the daemon is stopped
+func runDaemon(cf configFlags) int {
+	schedule, err := parseCron(config.Daemon.Schedule)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid daemon.schedule: "+err.Error())
+		return exitFatal
+	}
+	if _, err := parseBlackouts(config.Daemon.Blackout); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid daemon.blackout: "+err.Error())
+		return exitFatal
+	}
+	if config.Status.Address != "" {
+		startStatusServer(config.Status.Address)
+	}
+	watchConfig(*cf.file, *cf.profile, *cf.tenant)
+
+	//systemd and docker stop the daemon with SIGTERM, a run in progress stops at the next safe point
+	handleSignals(func() {
+		sdNotify("STOPPING=1")
+		requestDaemonStop()
+	})
+
+	writeInfo("Daemon started with " + versionString() + ", schedule " + config.Daemon.Schedule)
+	sdNotify("READY=1")
+	startWatchdog()
+
+	expr := config.Daemon.Schedule
+	scheduled := schedule.next(time.Now())
+	for {
+		if scheduled.IsZero() {
+			writeWarn("The schedule " + expr + " never runs, waiting for the config to change")
+		} else {
+			writeInfo("Next run at " + scheduled.Format("2006-01-02 15:04"))
+			sdNotify("STATUS=Next run at " + scheduled.Format("2006-01-02 15:04"))
+		}
+
+		//A reloaded config can change the schedule while waiting
+		due := scheduled.Add(daemonJitter())
+		current, stopping := waitForRun(scheduled, due, expr)
+		if stopping {
+			writeInfo("Daemon stopped")
+			return 0
+		}
+		if current != expr {
+			expr = current
+			if s, err := parseCron(current); err != nil {
+				writeWarn("Keeping the previous schedule, the new daemon.schedule is invalid: " + err.Error())
+			} else {
+				writeInfo("Schedule changed to " + current)
+				schedule = s
+			}
+			scheduled = schedule.next(time.Now())
+			continue
+		}
+
+		//Never start during a blackout, run when it ends instead
+		if end, window := daemonBlackout(time.Now()); !end.IsZero() {
+			writeWarnFields("Not running at "+scheduled.Format("2006-01-02 15:04")+" during the blackout "+window+
+				", rescheduled to "+end.Format("2006-01-02 15:04"), logFields{"blackout": window})
+			scheduled = end
+			continue
+		}
+
+		if time.Since(due) > missedRunGrace && strings.EqualFold(config.Daemon.CatchUp, "skip") {
+			writeWarn("Skipping the run missed at " + scheduled.Format("2006-01-02 15:04"))
+		} else {
+			sdNotify("STATUS=Running")
+			sdWatchdog()
+			//Dynamic credentials from vault that could no longer be renewed would fail the run
+			if takeVaultExpired() {
+				writeInfo("Secrets from vault have expired, resolving them again")
+				reloadConfig(*cf.file, *cf.profile, *cf.tenant)
+			}
+			daemonRun(*cf.profile, *cf.tenant, *cf.verbose)
+		}
+
+		//Runs scheduled while the last one was running are missed, catch up with a single run or move on
+		following := schedule.next(scheduled)
+		if !following.IsZero() && time.Since(following) > 0 {
+			if strings.EqualFold(config.Daemon.CatchUp, "skip") {
+				writeWarn("Skipping the run missed at " + following.Format("2006-01-02 15:04"))
+				following = schedule.next(time.Now())
+			} else {
+				writeInfo("Catching up with the run missed at " + following.Format("2006-01-02 15:04"))
+				following = time.Now()
+			}
+		}
+		scheduled = following
+	}
+}
+
+// Sleep until the run is due, waking regularly so a changed schedule or a clock that jumped (e.g. after the machine
+// was asleep) is noticed. Returns the schedule in the config, which differs from expr when it changed, and whether
+// the daemon is stopping
+func waitForRun(scheduled time.Time, due time.Time, expr string) (string, bool) {
+	for {
+		select {
+		case <-stopDaemon:
+			return expr, true
+		default:
+		}
+		sdWatchdog()
+		configLock.Lock()
+		current := config.Daemon.Schedule
+		configLock.Unlock()
+		if current != expr {
+			return current, false
+		}
+		if !scheduled.IsZero() && !time.Now().Before(due) {
+			return expr, false
+		}
+
+		wait := 30 * time.Second
+		if until := time.Until(due); !scheduled.IsZero() && until < wait {
+			wait = until
+		}
+		//Wake in time for the next watchdog ping
+		if watchdogInterval > 0 && watchdogInterval/2 < wait {
+			wait = watchdogInterval / 2
+		}
+		select {
+		case <-stopDaemon:
+			return expr, true
+		case <-time.After(wait):
+		}
+	}
+}
+
+// When the blackout from the config covering t ends, and which window it is. A config reloaded with invalid windows
+// keeps none rather than stopping the daemon
+func daemonBlackout(t time.Time) (time.Time, string) {
+	configLock.Lock()
+	windows, err := parseBlackouts(config.Daemon.Blackout)
+	configLock.Unlock()
+	if err != nil {
+		writeWarn("Ignoring daemon.blackout: " + err.Error())
+		return time.Time{}, ""
+	}
+	return blackoutEnd(windows, t)
+}
+
+// A random delay up to daemon.jitter, so sites sharing a schedule don't all hit the servers at once
+func daemonJitter() time.Duration {
+	if config.Daemon.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(config.Daemon.Jitter)))
+}
+
+// Run one sync in this process, starting from a clean state. An error that stops the run is logged and the daemon
+// carries on to the next one
+func daemonRun(profile string, tenant string, verbose bool) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	defer watchdogDuringRun()()
+
+	resetRunState()
+	//Reopen the log so a daemon running for days writes to the file for the current date
+	if err := setupLogging(tenant, verbose); err != nil {
+		fmt.Fprintln(os.Stderr, "Skipping the run: "+redact(err.Error()))
+		return
+	}
+
+	if tenant == "" && len(config.Tenants) > 0 {
+		runTenants(profile)
+		return
+	}
+	runSync()
+}
+
+// Clear everything left over from the previous run and give the next one its own id
+func resetRunState() {
+	dbComputers = nil
+	matcher = syncengine.NewMatcher(nil, syncengine.NameRules{})
+	dbOrganizations = nil
+	reportOnly = false
+	computerSources = map[string][]string{}
+	directoryIDs = map[string]string{}
+	reappeared = map[string]string{}
+	deletedOUs = nil
+	sourcesRead = map[string]time.Time{}
+	checksPassed = nil
+	dueRemovals = map[string]pendingRemoval{}
+	stats = runStats{Sources: map[string]int{}}
+	runID = randomHex(6)
+	traceID = randomHex(16)
+	spans = nil
+	openSpans = nil
+	runSpan = startSpan("polarissync")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Computers split by where they were found, with the sources each directory entry came from
+type computerDiff struct {
+	PolarisOnly   []string            `json:"polarisOnly"`
+	DirectoryOnly []string            `json:"directoryOnly"`
+	Both          []string            `json:"both"`
+	Sources       map[string][]string `json:"sources"`
+}
+
+func runDiffCommand(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	fs.StringVar(&outputFormat, "output-format", "text", "print the results as text or json")
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %s\n", outputFormat)
+		return exitUsage
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	if *cf.tenant == "" && len(config.Tenants) > 0 {
+		fmt.Fprintln(os.Stderr, "Select the tenant to compare with -tenant")
+		return exitUsage
+	}
+
+	if err := loadComputers(); err != nil {
+		return exitWithError(err)
+	}
+	d := diffComputers()
+
+	if outputFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(d)
+		return 0
+	}
+	section := func(heading string, names []string) {
+		fmt.Printf("%s (%d)\n", heading, len(names))
+		for _, name := range names {
+			fmt.Printf("  %-20s %s\n", name, strings.Join(d.Sources[name], ", "))
+		}
+		fmt.Println()
+	}
+	section("Only in Polaris", d.PolarisOnly)
+	section("Only in the directory", d.DirectoryOnly)
+	section("In both", d.Both)
+	return 0
+}
+
+// Compare the computers loaded from the database with those from the directory sources
+func diffComputers() computerDiff {
+	d := computerDiff{PolarisOnly: []string{}, DirectoryOnly: []string{}, Both: []string{}, Sources: computerSources}
+	for name, sources := range computerSources {
+		inPolaris, inDirectory := false, false
+		for _, source := range sources {
+			if source == "polaris" {
+				inPolaris = true
+			} else {
+				inDirectory = true
+			}
+		}
+		switch {
+		case inPolaris && inDirectory:
+			d.Both = append(d.Both, name)
+		case inPolaris:
+			d.PolarisOnly = append(d.PolarisOnly, name)
+		default:
+			d.DirectoryOnly = append(d.DirectoryOnly, name)
+		}
+	}
+	sort.Strings(d.PolarisOnly)
+	sort.Strings(d.DirectoryOnly)
+	sort.Strings(d.Both)
+	return d
+}
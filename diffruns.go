@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How the computers a source listed changed between two runs
+type sourceChange struct {
+	Source      string   `json:"source"`
+	Before      int      `json:"before"`
+	After       int      `json:"after"`
+	Appeared    []string `json:"appeared"`
+	Disappeared []string `json:"disappeared"`
+}
+
+// The differences between two runs, and what the later run did about them
+type runDiff struct {
+	From    historyRun          `json:"from"`
+	To      historyRun          `json:"to"`
+	Sources []sourceChange      `json:"sources"`
+	Actions map[string][]string `json:"actions"`
+}
+
+// Compare the computers two runs saw, by default the last two, to find out why a run did what it did
+func runDiffRunsCommand(args []string) int {
+	fs := flag.NewFlagSet("diff-runs", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	fs.StringVar(&outputFormat, "output-format", "text", "print the results as text or json")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: polarissync diff-runs [flags] [<earlier run id> <later run id>]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if fs.NArg() != 0 && fs.NArg() != 2 {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	if config.History.File == "" {
+		fmt.Fprintln(os.Stderr, "There is no run history, set history.file in the config")
+		return exitFatal
+	}
+
+	db, err := openHistory()
+	if err != nil {
+		return exitWithError(err)
+	}
+	defer db.Close()
+	from, to := fs.Arg(0), fs.Arg(1)
+	if fs.NArg() == 0 {
+		//The runs of every tenant are kept together, the last two would otherwise be from different tenants
+		if *cf.tenant == "" && len(config.Tenants) > 0 {
+			fmt.Fprintln(os.Stderr, "Select the tenant whose runs to compare with -tenant")
+			return exitUsage
+		}
+		if from, to, err = lastTwoSnapshots(db, *cf.tenant); err != nil {
+			return exitWithError(err)
+		}
+	}
+	d, err := diffRuns(db, from, to)
+	if err != nil {
+		return exitWithError(err)
+	}
+
+	if outputFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(d)
+		return 0
+	}
+	fmt.Printf("From run %s (%s) to run %s (%s)\n", d.From.RunId, d.From.Start.Local().Format("2006-01-02 15:04"),
+		d.To.RunId, d.To.Start.Local().Format("2006-01-02 15:04"))
+	list := func(heading string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		fmt.Printf("  %s (%d)\n", heading, len(names))
+		for _, name := range names {
+			fmt.Printf("    %s\n", name)
+		}
+	}
+	for _, s := range d.Sources {
+		fmt.Printf("\n%s: %d to %d computers\n", s.Source, s.Before, s.After)
+		list("Appeared", s.Appeared)
+		list("Disappeared", s.Disappeared)
+	}
+	decisions := []string{}
+	for decision := range d.Actions {
+		decisions = append(decisions, decision)
+	}
+	sort.Strings(decisions)
+	if len(decisions) > 0 {
+		fmt.Printf("\nWhat run %s did\n", d.To.RunId)
+	}
+	for _, decision := range decisions {
+		list(strings.ToUpper(decision[:1])+decision[1:], d.Actions[decision])
+	}
+	return 0
+}
+
+// The ids of the two most recent runs with a snapshot of their computers, earlier first
+func lastTwoSnapshots(db *sql.DB, tenant string) (string, string, error) {
+	rows, err := db.Query("select run_id from runs where tenant = ? and run_id in (select run_id from run_computers) "+
+		"order by start_time desc, rowid desc limit 2", tenant)
+	if err != nil {
+		return "", "", err
+	}
+	defer rows.Close()
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return "", "", err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return "", "", err
+	}
+	if len(ids) < 2 {
+		return "", "", fmt.Errorf("the history needs two runs with their computers saved to compare")
+	}
+	return ids[1], ids[0], nil
+}
+
+// Compare the computers each source listed in two runs, and list what the later run did
+func diffRuns(db *sql.DB, fromID string, toID string) (runDiff, error) {
+	d := runDiff{Sources: []sourceChange{}, Actions: map[string][]string{}}
+	var err error
+	if d.From, err = historyRunByID(db, fromID); err != nil {
+		return d, err
+	}
+	if d.To, err = historyRunByID(db, toID); err != nil {
+		return d, err
+	}
+	before, err := runSnapshot(db, fromID)
+	if err != nil {
+		return d, err
+	}
+	after, err := runSnapshot(db, toID)
+	if err != nil {
+		return d, err
+	}
+
+	sources := map[string]bool{}
+	for source := range before {
+		sources[source] = true
+	}
+	for source := range after {
+		sources[source] = true
+	}
+	for source := range sources {
+		c := sourceChange{Source: source, Before: len(before[source]), After: len(after[source]), Appeared: []string{}, Disappeared: []string{}}
+		for name := range after[source] {
+			if !before[source][name] {
+				c.Appeared = append(c.Appeared, name)
+			}
+		}
+		for name := range before[source] {
+			if !after[source][name] {
+				c.Disappeared = append(c.Disappeared, name)
+			}
+		}
+		sort.Strings(c.Appeared)
+		sort.Strings(c.Disappeared)
+		d.Sources = append(d.Sources, c)
+	}
+	sort.Slice(d.Sources, func(i, j int) bool { return d.Sources[i].Source < d.Sources[j].Source })
+
+	//Keeping a computer is the normal case, the changes and what held them back are what explain a run
+	rows, err := db.Query("select decision, computer from run_actions where run_id = ? and decision != 'keep' order by computer", toID)
+	if err != nil {
+		return d, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var decision, computer string
+		if err = rows.Scan(&decision, &computer); err != nil {
+			return d, err
+		}
+		d.Actions[decision] = append(d.Actions[decision], computer)
+	}
+	return d, rows.Err()
+}
+
+// A run from the history by its id
+func historyRunByID(db *sql.DB, id string) (historyRun, error) {
+	var r historyRun
+	var start, sources string
+	err := db.QueryRow("select run_id, tenant, start_time, success, sources, orphans, removed, errors from runs where run_id = ?", id).
+		Scan(&r.RunId, &r.Tenant, &start, &r.Success, &sources, &r.Orphans, &r.Removed, &r.Errors)
+	if err == sql.ErrNoRows {
+		return r, fmt.Errorf("there is no run %s in the history", id)
+	}
+	if err != nil {
+		return r, err
+	}
+	r.Start, _ = time.Parse(time.RFC3339, start)
+	json.Unmarshal([]byte(sources), &r.Sources)
+	return r, nil
+}
+
+// The computers each source listed in a run, by source
+func runSnapshot(db *sql.DB, id string) (map[string]map[string]bool, error) {
+	rows, err := db.Query("select source, computer from run_computers where run_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snapshot := map[string]map[string]bool{}
+	for rows.Next() {
+		var source, computer string
+		if err = rows.Scan(&source, &computer); err != nil {
+			return nil, err
+		}
+		if snapshot[source] == nil {
+			snapshot[source] = map[string]bool{}
+		}
+		snapshot[source][computer] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(snapshot) == 0 {
+		return nil, fmt.Errorf("the computers of run %s weren't saved, it ran before snapshots were kept", id)
+	}
+	return snapshot, nil
+}
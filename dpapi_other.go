@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "fmt"
+
+func dpapiProtect(data []byte) ([]byte, error) {
+	return nil, fmt.Errorf("dpapi is only available on windows")
+}
+
+func dpapiUnprotect(data []byte) ([]byte, error) {
+	return nil, fmt.Errorf("dpapi is only available on windows")
+}
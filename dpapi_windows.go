@@ -0,0 +1,31 @@
+package main
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Secrets are encrypted with the machine key rather than the user key, since the sync usually runs as a service
+// account other than the admin who protected the config
+const dpapiFlags = windows.CRYPTPROTECT_UI_FORBIDDEN | windows.CRYPTPROTECT_LOCAL_MACHINE
+
+func dpapiProtect(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	if err := windows.CryptProtectData(&in, nil, nil, 0, nil, dpapiFlags, &out); err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
+
+func dpapiUnprotect(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	if err := windows.CryptUnprotectData(&in, nil, nil, 0, nil, dpapiFlags, &out); err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
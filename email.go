@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mail the run summary to the configured recipients
+func sendEmailReport() error {
+	e := config.Email
+	if len(e.To) == 0 {
+		return fmt.Errorf("no recipients configured")
+	}
+	address := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	tlsConfig := &tls.Config{ServerName: e.Host}
+
+	//Implicit TLS is usually port 465, starttls upgrades a plain connection, usually on port 587
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if strings.EqualFold(e.Tls, "tls") {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if strings.EqualFold(e.Tls, "starttls") {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	message, err := emailMessage()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(message); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Build the mail, with the html report attached when configured
+func emailMessage() ([]byte, error) {
+	e := config.Email
+	var b bytes.Buffer
+	b.WriteString("From: " + e.From + "\r\n" +
+		"To: " + strings.Join(e.To, ", ") + "\r\n" +
+		"Subject: " + summaryTitle() + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n")
+	body, err := emailBody()
+	if err != nil {
+		return nil, err
+	}
+	if approvalLinksEnabled() && len(stats.Pending) > 0 {
+		body += approvalLinksText()
+	}
+	text := strings.ReplaceAll(body, "\n", "\r\n")
+	if !e.AttachReport {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n" + text)
+		return b.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&b)
+	b.WriteString("Content-Type: multipart/mixed; boundary=" + mw.Boundary() + "\r\n\r\n")
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(text))
+
+	var report bytes.Buffer
+	if err = writeHTMLReport(&report); err != nil {
+		return nil, err
+	}
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {`attachment; filename="polarissync-` + runID + `.html"`},
+	})
+	if err != nil {
+		return nil, err
+	}
+	//Base64 lines are limited to 76 characters
+	encoded := base64.StdEncoding.EncodeToString(report.Bytes())
+	for len(encoded) > 76 {
+		part.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	part.Write([]byte(encoded + "\r\n"))
+	if err = mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// The text of the mail, the summary or the email template when there is one
+func emailBody() (string, error) {
+	text, err := readTemplate(config.Email.Template, config.Email.TemplateFile)
+	if err != nil || text == "" {
+		return summaryText(), err
+	}
+	body, err := renderTemplate("email", text)
+	return string(body), err
+}
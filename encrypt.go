@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypt every plain text password in the config file as an ENC(...) value using the master key
+func runEncryptConfig(path string) int {
+	return rewriteSecrets(path, "Encrypted", func(value string) (string, bool, error) {
+		if !isPlainSecret(value) {
+			return "", false, nil
+		}
+		encrypted, err := encryptConfigValue(value)
+		return encrypted, err == nil, err
+	})
+}
+
+// Turn every ENC(...) value in the config file back into plain text, e.g. to change a password
+func runDecryptConfig(path string) int {
+	return rewriteSecrets(path, "Decrypted", func(value string) (string, bool, error) {
+		if !isEncryptedValue(value) {
+			return "", false, nil
+		}
+		plain, err := decryptConfigValue(value)
+		return plain, err == nil, err
+	})
+}
+
+func isEncryptedValue(value string) bool {
+	return strings.HasPrefix(value, "ENC(") && strings.HasSuffix(value, ")")
+}
+
+// The master key is read from POLARISSYNC_MASTER_KEY or from the file named by masterKeyFile. Any text can be used
+// as the key, it is hashed to get an AES-256 key
+func masterKey() ([]byte, error) {
+	key := os.Getenv("POLARISSYNC_MASTER_KEY")
+	if key == "" && config.MasterKeyFile != "" {
+		data, err := os.ReadFile(configRelativePath(config.MasterKeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read master key file: %w", err)
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		return nil, fmt.Errorf("no master key, set POLARISSYNC_MASTER_KEY or masterKeyFile")
+	}
+	sum := sha256.Sum256([]byte(key))
+	return sum[:], nil
+}
+
+func configCipher() (cipher.AEAD, error) {
+	key, err := masterKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encryptConfigValue(value string) (string, error) {
+	gcm, err := configCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return "ENC(" + base64.StdEncoding.EncodeToString(sealed) + ")", nil
+}
+
+func decryptConfigValue(value string) (string, error) {
+	gcm, err := configCipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(value, "ENC("), ")"))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted value")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt value, check the master key: %w", err)
+	}
+	return string(plain), nil
+}
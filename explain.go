@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Show how a sync would treat one computer and why, by running the comparison read only
+func runExplainCommand(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: polarissync explain [flags] <computer>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	if *cf.tenant == "" && len(config.Tenants) > 0 {
+		fmt.Fprintln(os.Stderr, "Select the tenant to explain with -tenant")
+		return exitUsage
+	}
+
+	//The same comparison as a sync, so the answer can't drift from what a sync does
+	reportOnly = true
+	if err := loadComputers(); err != nil {
+		return exitWithError(err)
+	}
+	if err := findComputersToRemoveFromDB(); err != nil {
+		return exitWithError(err)
+	}
+	if err := findComputersToAddToDB(); err != nil {
+		return exitWithError(err)
+	}
+
+	for _, line := range explainComputer(fs.Arg(0)) {
+		fmt.Printf("%-12s %s\n", line[0]+":", line[1])
+	}
+	return 0
+}
+
+// The facts about a computer that decide what a sync does with it, as label and value pairs
+func explainComputer(name string) [][2]string {
+	name = normalize(name)
+	lines := [][2]string{{"Computer", name}}
+
+	inPolaris := false
+	directory := []string{}
+	for _, source := range computerSources[name] {
+		if source == "polaris" {
+			inPolaris = true
+		} else {
+			directory = append(directory, source)
+		}
+	}
+	lines = append(lines, [2]string{"In Polaris", yesNo(inPolaris)})
+	if len(directory) == 0 {
+		lines = append(lines, [2]string{"Sources", "not found in " + strings.Join(enabledSources(), " or ")})
+	} else {
+		lines = append(lines, [2]string{"Sources", strings.Join(directory, ", ")})
+	}
+
+	exemption := "none"
+	for _, e := range config.Database.ExemptComputers {
+		if normalize(e) == name {
+			exemption = e + " in database.exemptComputers"
+			break
+		}
+	}
+	lines = append(lines, [2]string{"Exemption", exemption})
+	if policy, rule := nameRule(name); rule != "" {
+		lines = append(lines, [2]string{"Rule", policy + ", matches " + rule})
+	} else {
+		lines = append(lines, [2]string{"Rule", "none"})
+	}
+
+	for _, d := range stats.Decisions {
+		if normalize(d.Computer) != name {
+			continue
+		}
+		lines = append(lines, [2]string{"Decision", strings.TrimPrefix(d.Decision, "would ") + ", " + d.Reason})
+		//The safety limits apply to the removals as a whole
+		if d.Decision == "would remove" {
+			tripped := ""
+			if config.Safety.MaxRemovals > 0 && stats.Orphans > config.Safety.MaxRemovals {
+				tripped = fmt.Sprintf("%d computers to remove is more than the limit of %d", stats.Orphans, config.Safety.MaxRemovals)
+			} else if reason, err := checkSourceCounts(); err != nil {
+				tripped = err.Error()
+			} else {
+				tripped = reason
+			}
+			if tripped != "" {
+				lines = append(lines, [2]string{"Safety", "removals would be skipped, " + tripped})
+			}
+		}
+		return lines
+	}
+	return append(lines, [2]string{"Decision", "none, not in Polaris or any source"})
+}
@@ -0,0 +1,119 @@
+// Package fixture stands in for the directory and the Polaris database with computer names read from JSON files,
+// so a sync can be rehearsed offline
+package fixture
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/venutios/polarissync/polarisdb"
+)
+
+// A directory source read from a JSON array of computer names, or of objects with a name and an id to stand in for
+// the AD objectGUID
+type Directory struct {
+	File string
+}
+
+// A directory computer in a fixture
+type computer struct {
+	Name string
+	Id   string
+}
+
+func (d Directory) load() ([]computer, error) {
+	var computers []computer
+	data, err := os.ReadFile(d.File)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read fixture: %w", err)
+	}
+	var names []string
+	if json.Unmarshal(data, &names) == nil {
+		for _, name := range names {
+			computers = append(computers, computer{Name: name})
+		}
+		return computers, nil
+	}
+	if err = json.Unmarshal(data, &computers); err != nil {
+		return nil, fmt.Errorf("fixture %s is not valid: %w", d.File, err)
+	}
+	return computers, nil
+}
+
+func (d Directory) Computers(emit func(name string)) (int, error) {
+	return d.IdentifiedComputers(func(name string, id string) {
+		emit(name)
+	})
+}
+
+func (d Directory) IdentifiedComputers(emit func(name string, id string)) (int, error) {
+	computers, err := d.load()
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range computers {
+		emit(c.Name, c.Id)
+	}
+	return len(computers), nil
+}
+
+// The contents of a database fixture. A plain JSON array of names is also accepted, for the workstations alone
+type databaseFile struct {
+	Workstations  []string
+	Organizations []polarisdb.Organization
+}
+
+// A database read from a fixture file. Changes are accepted and thrown away, so nothing is ever written
+type Database struct {
+	File string
+}
+
+func (d Database) load() (databaseFile, error) {
+	var f databaseFile
+	data, err := os.ReadFile(d.File)
+	if err != nil {
+		return f, fmt.Errorf("unable to read fixture: %w", err)
+	}
+	if json.Unmarshal(data, &f.Workstations) == nil {
+		return f, nil
+	}
+	if err = json.Unmarshal(data, &f); err != nil {
+		return f, fmt.Errorf("fixture %s is not valid: %w", d.File, err)
+	}
+	return f, nil
+}
+
+func (d Database) Workstations() ([]string, error) {
+	f, err := d.load()
+	return f.Workstations, err
+}
+
+func (d Database) Organizations() ([]polarisdb.Organization, error) {
+	f, err := d.load()
+	for i := range f.Organizations {
+		f.Organizations[i].Abbreviation = strings.ToUpper(f.Organizations[i].Abbreviation)
+	}
+	return f.Organizations, err
+}
+
+func (d Database) DeleteWorkstation(name string) error {
+	return nil
+}
+
+func (d Database) RenameWorkstation(oldName string, newName string) error {
+	return nil
+}
+
+func (d Database) AddWorkstation(orgID int, name string) (int64, error) {
+	return 0, nil
+}
+
+func (d Database) AddToGroup(groupID int, workstationID int64) error {
+	return nil
+}
+
+func (d Database) Close() error {
+	return nil
+}
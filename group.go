@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/venutios/polarissync/sources/ad"
+)
+
+// A section of a report, the computers of one branch or OU
+type reportGroup struct {
+	Name      string
+	Computers []string
+}
+
+// The OU each computer was deleted from, by normalized name, read from the AD recycle bin the first time a report
+// groups by OU
+var deletedOUs map[string]string
+
+// The section a computer is reported under with report.groupBy, its Polaris branch or the AD OU it was deleted from
+func groupOf(name string) string {
+	switch strings.ToLower(config.Report.GroupBy) {
+	case "branch":
+		if branch := branchOf(name); branch != "" {
+			return branch
+		}
+		return "No branch"
+	case "ou":
+		if deletedOUs == nil {
+			loadDeletedOUs()
+		}
+		if ou := deletedOUs[normalize(name)]; ou != "" {
+			return ou
+		}
+		return "Unknown OU"
+	}
+	return ""
+}
+
+// Read the OU of each computer in the AD recycle bin. A computer that was removed from Polaris has gone from the
+// directory, so the recycle bin is the only place that still knows where it was
+func loadDeletedOUs() {
+	deletedOUs = map[string]string{}
+	if !config.ActiveDirectory.Enabled || !strings.EqualFold(config.ActiveDirectory.Backend, "ldap") {
+		return
+	}
+	_, err := ad.DeletedComputers(adOptions(), func(name string, parent string) {
+		deletedOUs[normalize(name)] = ad.OUPath(parent)
+	})
+	if err != nil {
+		writeWarn("Unable to read the OUs of deleted computers from the AD recycle bin: " + err.Error())
+	}
+}
+
+// Split the computers into the sections of report.groupBy, sorted by name. Without grouping there is a single
+// section with no name
+func groupComputers(names []string) []reportGroup {
+	groups := []reportGroup{}
+	index := map[string]int{}
+	for _, name := range names {
+		group := groupOf(name)
+		i, ok := index[group]
+		if !ok {
+			i = len(groups)
+			index[group] = i
+			groups = append(groups, reportGroup{Name: group})
+		}
+		groups[i].Computers = append(groups[i].Computers, name)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
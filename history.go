@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Open the local run history, creating the tables on first use
+func openHistory() (*sql.DB, error) {
+	db, err := sql.Open("sqlite", configRelativePath(config.History.File))
+	if err != nil {
+		return nil, err
+	}
+	//A single connection so the busy timeout applies to every statement, tenants running in parallel share the file
+	db.SetMaxOpenConns(1)
+	statements := []string{
+		"pragma busy_timeout = 10000",
+		`create table if not exists runs (
+			run_id text primary key, tenant text, start_time text, end_time text, success integer, report_only integer,
+			fatal text, tripped text, sources text, orphans integer, exempt integer, removed integer,
+			remove_failed integer, added integer, add_failed integer, errors integer, config_hash text)`,
+		`create table if not exists run_actions (
+			run_id text, computer text, decision text, reason text, sources text, time text)`,
+		"create index if not exists run_actions_computer on run_actions (computer)",
+		"create table if not exists run_errors (run_id text, message text)",
+		//Every computer each source listed, by normalized name, so two runs can be compared
+		"create table if not exists run_computers (run_id text, source text, computer text)",
+		"create index if not exists run_computers_run on run_computers (run_id)",
+		//Renames added with the renames command, old name to new name by normalized name
+		`create table if not exists renames (
+			tenant text, old_name text, new_name text, added_by text, added_time text, primary key (tenant, old_name))`,
+	}
+	for _, s := range statements {
+		if _, err = db.Exec(s); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// Save the results of the run to the history
+func recordHistory() error {
+	db, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sources, _ := json.Marshal(stats.Sources)
+	_, err = tx.Exec("insert into runs values (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", runID, tenantName,
+		stats.Start.UTC().Format(time.RFC3339), stats.End.UTC().Format(time.RFC3339), !stats.failed(), reportOnly,
+		stats.Fatal, stats.Tripped, string(sources), stats.Orphans, stats.Exempt, stats.Removed, stats.RemoveFailed,
+		stats.Added, stats.AddFailed, len(stats.Errors), configFingerprint())
+	if err != nil {
+		return err
+	}
+	for _, d := range stats.Decisions {
+		_, err = tx.Exec("insert into run_actions values (?,?,?,?,?,?)", runID, d.Computer, d.Decision, d.Reason,
+			strings.Join(d.Sources, ","), d.Time.UTC().Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range stats.Errors {
+		if _, err = tx.Exec("insert into run_errors values (?,?)", runID, e); err != nil {
+			return err
+		}
+	}
+	for name, sources := range computerSources {
+		for _, source := range sources {
+			if _, err = tx.Exec("insert into run_computers values (?,?,?)", runID, source, name); err != nil {
+				return err
+			}
+		}
+	}
+	if err = pruneHistory(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Drop the runs older than history.retention, and the computers seen by all but the last history.snapshotRuns
+// runs of the tenant, which would otherwise grow the file by every computer on every run
+func pruneHistory(tx *sql.Tx) error {
+	if config.History.Retention > 0 {
+		cutoff := clock.Now().Add(-config.History.Retention).UTC().Format(time.RFC3339)
+		for _, table := range []string{"run_actions", "run_errors", "run_computers"} {
+			_, err := tx.Exec("delete from "+table+" where run_id in (select run_id from runs where start_time < ?)", cutoff)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("delete from runs where start_time < ?", cutoff); err != nil {
+			return err
+		}
+	}
+	if config.History.SnapshotRuns > 0 {
+		_, err := tx.Exec(`delete from run_computers where run_id in (select run_id from runs where tenant = ?
+			and run_id not in (select run_id from runs where tenant = ? order by start_time desc, rowid desc limit ?))`,
+			tenantName, tenantName, config.History.SnapshotRuns)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// What a hook command is given on stdin. The postRun hook also gets the results of the run
+type hookContext struct {
+	Hook       string     `json:"hook"`
+	RunId      string     `json:"runId"`
+	Tenant     string     `json:"tenant,omitempty"`
+	ReportOnly bool       `json:"reportOnly"`
+	Computer   string     `json:"computer,omitempty"`
+	Result     *runResult `json:"result,omitempty"`
+}
+
+// Run a hook command through the shell with the run context in the environment and as json on stdin, so sites can
+// do their own clean up alongside the sync. Nothing is run when simulating. The output of the command is logged
+func runHook(hook string, command string, hc hookContext) error {
+	if command == "" || simulate {
+		return nil
+	}
+	hc.Hook = hook
+	hc.RunId = runID
+	hc.Tenant = tenantName
+	hc.ReportOnly = reportOnly
+	input, err := json.Marshal(hc)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Hooks.Timeout)
+	defer cancel()
+	cmd := shellCommand(command)
+	cmd.Env = append(hookEnvironment(), "POLARISSYNC_HOOK="+hook, "POLARISSYNC_RUN_ID="+runID, "POLARISSYNC_TENANT="+tenantName,
+		fmt.Sprintf("POLARISSYNC_REPORT_ONLY=%t", reportOnly), "POLARISSYNC_COMPUTER="+hc.Computer)
+	cmd.Stdin = bytes.NewReader(input)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	span := startSpan(hook + " hook")
+	defer span.finish()
+	//Killing only the shell would leave the commands it started running, and holding the output open
+	if err = cmd.Start(); err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killHook(cmd)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	output := strings.TrimSpace(out.String())
+	if output != "" {
+		writeDebugFields("Output of the "+hook+" hook: "+output, logFields{"hook": hook})
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", config.Hooks.Timeout)
+	}
+	if err != nil {
+		span.fail(err.Error())
+		if output != "" {
+			return fmt.Errorf("%s hook failed: %w: %s", hook, err, output)
+		}
+		return fmt.Errorf("%s hook failed: %w", hook, err)
+	}
+	return nil
+}
+
+// Credentials in the environment of the sync that a hook has no use for
+var hookSecretVariables = map[string]bool{
+	"POLARISSYNC_MASTER_KEY": true, "VAULT_TOKEN": true, "AWS_SECRET_ACCESS_KEY": true, "AWS_SESSION_TOKEN": true,
+}
+
+// The environment of the sync without its credentials, including settings supplied as variables such as
+// POLARISSYNC_DATABASE_PASSWORD
+func hookEnvironment() []string {
+	env := []string{}
+	for _, variable := range os.Environ() {
+		name, value := variable, ""
+		if i := strings.Index(variable, "="); i > 0 {
+			name, value = variable[:i], variable[i+1:]
+		}
+		if hookSecretVariables[strings.ToUpper(name)] {
+			continue
+		}
+		if key := strings.ToLower(name); strings.HasPrefix(key, "polarissync_") {
+			key = strings.ReplaceAll(strings.TrimPrefix(key, "polarissync_"), "_", ".")
+			if strings.HasSuffix(key, "headers") || len(secretValues(key, value)) > 0 {
+				continue
+			}
+		}
+		env = append(env, variable)
+	}
+	return env
+}
+
+// Run the postRemove hook for a computer that was removed. A failure is reported but doesn't undo the removal
+func runPostRemoveHook(name string) {
+	if err := runHook("postRemove", config.Hooks.PostRemove, hookContext{Computer: name}); err != nil {
+		stats.addError(err.Error())
+		writeWarnFields(err.Error(), logFields{"computer": name, "hook": "postRemove"})
+	}
+}
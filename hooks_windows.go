@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// A hook command run by cmd, so batch files and powershell -File both work
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+// Kill the process tree of a hook, cmd and everything it started
+func killHook(cmd *exec.Cmd) {
+	if exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run() != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// The normalized name of each computer read this run, by its AD objectGUID
+var directoryIDs = map[string]string{}
+
+// The identities file for the tenant being synced
+func identitiesPath() string {
+	return configRelativePath(logFileName(config.Identities.File, tenantName, stats.Start))
+}
+
+// The names seen for each objectGUID at the end of the last run, empty before the first
+func loadIdentities() (map[string]string, error) {
+	identities := map[string]string{}
+	data, err := os.ReadFile(identitiesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return identities, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &identities); err != nil {
+		return nil, fmt.Errorf("%s is not valid: %w", identitiesPath(), err)
+	}
+	return identities, nil
+}
+
+// Computers whose objectGUID was last seen under a different name, old name to new name
+func detectedRenames() map[string]string {
+	renames := map[string]string{}
+	if config.Identities.File == "" || len(directoryIDs) == 0 {
+		return renames
+	}
+	identities, err := loadIdentities()
+	if err != nil {
+		writeWarn("Unable to read the identities file, renames will not be detected: " + err.Error())
+		return renames
+	}
+	for id, oldName := range identities {
+		if newName, ok := directoryIDs[id]; ok && newName != normalize(oldName) {
+			renames[normalize(oldName)] = newName
+		}
+	}
+	return renames
+}
+
+// Save the names seen this run for the next one. Nothing is saved by a report or simulation, which would hide the
+// renames from the run that applies them, nor when AD failed to load or a rename failed and needs trying again
+func recordIdentities() {
+	if config.Identities.File == "" || reportOnly || simulate || len(directoryIDs) == 0 || stats.RenameFailed > 0 {
+		return
+	}
+	for _, source := range stats.FailedSources {
+		if source == "ad" {
+			return
+		}
+	}
+	data, err := json.MarshalIndent(directoryIDs, "", "  ")
+	if err == nil {
+		err = os.WriteFile(identitiesPath(), data, 0644)
+	}
+	if err != nil {
+		writeWarn("Unable to save the identities file: " + err.Error())
+	}
+}
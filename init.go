@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	cfg "github.com/venutios/polarissync/config"
+	"golang.org/x/term"
+)
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// Ask a question on the console, returning the default when nothing is entered
+func prompt(label string, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", label, def)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	answer, _ := stdinReader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def
+	}
+	return answer
+}
+
+func promptBool(label string, def bool) bool {
+	d := "n"
+	if def {
+		d = "y"
+	}
+	return strings.HasPrefix(strings.ToLower(prompt(label+" (y/n)", d)), "y")
+}
+
+func promptInt(label string, def int) int {
+	for {
+		n, err := strconv.Atoi(prompt(label, strconv.Itoa(def)))
+		if err == nil {
+			return n
+		}
+		fmt.Println("Please enter a number")
+	}
+}
+
+// Keep asking for a section's settings until the connection test passes or the user accepts the failure
+func promptUntilValid(name string, ask func(), check func() error) {
+	for {
+		ask()
+		fmt.Printf("Testing %s... ", name)
+		err := check()
+		if err == nil {
+			fmt.Println("OK")
+			return
+		}
+		fmt.Println("FAILED")
+		fmt.Println(redact(err.Error()))
+		if !promptBool("Change the "+name+" settings", true) {
+			return
+		}
+	}
+}
+
+// Interactively build a config file, testing each connection as it is entered
+func runInit(path string) int {
+	//Passwords are read without echoing, which needs a console
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "init asks for the settings interactively and needs to run in a console")
+		return exitUsage
+	}
+	if _, err := os.Stat(path); err == nil {
+		if !promptBool(path+" already exists, overwrite it", false) {
+			return exitUsage
+		}
+	}
+
+	//Start from the defaults so every section is written, ready to be filled in later
+	cfg.SetDefaults()
+	if err := viper.Unmarshal(&config); err != nil {
+		return exitWithError(err)
+	}
+
+	fmt.Println("Polaris database")
+	promptUntilValid("database", func() {
+		config.Database.Host = prompt("  Server", "127.0.0.1")
+		config.Database.Port = promptInt("  Port", 1433)
+		config.Database.Name = prompt("  Database name", "Polaris")
+		config.Database.Trusted = promptBool("  Use a trusted connection", true)
+		if !config.Database.Trusted {
+			config.Database.Domain = prompt("  Domain", config.Database.Domain)
+			config.Database.Username = prompt("  Username", config.Database.Username)
+			config.Database.Password, _ = promptPassword("  Password")
+		}
+	}, func() error { return checkDatabase(readConnString()) })
+
+	fmt.Println("Active Directory")
+	config.ActiveDirectory.Enabled = promptBool("  Load computers from Active Directory", true)
+	if config.ActiveDirectory.Enabled {
+		promptUntilValid("active directory", func() {
+			config.ActiveDirectory.Host = prompt("  Domain controller", "127.0.0.1")
+			config.ActiveDirectory.Domain = prompt("  Domain", config.ActiveDirectory.Domain)
+			config.ActiveDirectory.Username = prompt("  Username", config.ActiveDirectory.Username)
+			config.ActiveDirectory.Password, _ = promptPassword("  Password")
+			config.ActiveDirectory.Dn = prompt("  Search base DN", config.ActiveDirectory.Dn)
+		}, checkLDAP)
+	}
+
+	fmt.Println("Azure AD")
+	config.Azure.Enabled = promptBool("  Load computers from Azure AD", false)
+	if config.Azure.Enabled {
+		promptUntilValid("azure", func() {
+			config.Azure.Domain = prompt("  Azure domain", config.Azure.Domain)
+			if !config.ActiveDirectory.Enabled {
+				config.ActiveDirectory.Username = prompt("  Username", config.ActiveDirectory.Username)
+				config.ActiveDirectory.Password, _ = promptPassword("  Password")
+			}
+		}, checkAzure)
+	}
+
+	fmt.Println("Logging")
+	config.Logging.Enabled = promptBool("  Write a log file", true)
+	config.Logging.Location = "."
+	if config.Logging.Enabled {
+		config.Logging.Location = prompt("  Log folder", ".")
+	}
+
+	exempt := prompt("Computers that should never be removed (comma separated)", "")
+	config.Database.ExemptComputers = []string{}
+	for _, c := range strings.Split(exempt, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			config.Database.ExemptComputers = append(config.Database.ExemptComputers, strings.ToUpper(c))
+		}
+	}
+
+	if err := writeInitConfig(path); err != nil {
+		fmt.Println("Unable to write the config file: " + err.Error())
+		return exitFatal
+	}
+	fmt.Println("Config written to " + path)
+	return exitSuccess
+}
+
+// Write every setting of the config to a new file, in the format that follows the extension of the path. The file
+// holds passwords, so it is only readable by its owner, and is written alongside then renamed so an existing file
+// doesn't keep its permissions
+func writeInitConfig(path string) error {
+	//A separate viper instance writes the file so the global one keeps only the defaults
+	v := viper.New()
+	v.SetConfigPermissions(0600)
+	for key, value := range initSettings(reflect.ValueOf(config)).(map[string]interface{}) {
+		v.Set(key, value)
+	}
+	//The temporary name keeps the extension, which viper picks the format from
+	temp := filepath.Join(filepath.Dir(path), ".new-"+filepath.Base(path))
+	if err := v.WriteConfigAs(temp); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+// The settings of a config as nested maps and lists under lower case keys, with durations written as text such
+// as 30s
+func initSettings(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		settings := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			settings[strings.ToLower(v.Type().Field(i).Name)] = initSettings(v.Field(i))
+		}
+		return settings
+	case reflect.Slice:
+		list := []interface{}{}
+		for i := 0; i < v.Len(); i++ {
+			list = append(list, initSettings(v.Index(i)))
+		}
+		return list
+	case reflect.Map:
+		settings := map[string]interface{}{}
+		iter := v.MapRange()
+		for iter.Next() {
+			settings[fmt.Sprint(iter.Key().Interface())] = initSettings(iter.Value())
+		}
+		return settings
+	}
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return v.Interface()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	keyVaultToken string
+	//When the token stops being accepted, so a daemon reloading its config hours later gets a new one
+	keyVaultExpiry time.Time
+)
+
+// Read a secret from Azure Key Vault given a reference in the form keyvault://vault/secret or
+// keyvault://vault/secret/version
+func resolveKeyVaultSecret(ref string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(ref, "keyvault://"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid key vault reference %s", ref)
+	}
+	secretURL := fmt.Sprintf("https://%s.vault.azure.net/secrets/%s", parts[0], strings.Join(parts[1:], "/")) + "?api-version=7.4"
+
+	if keyVaultToken == "" || time.Until(keyVaultExpiry) < 5*time.Minute {
+		token, expiry, err := keyVaultAccessToken()
+		if err != nil {
+			return "", fmt.Errorf("unable to get a key vault access token: %w", err)
+		}
+		keyVaultToken, keyVaultExpiry = token, expiry
+	}
+
+	req, err := http.NewRequest("GET", secretURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+keyVaultToken)
+
+	var secret struct {
+		Value string
+	}
+	if err = doJSONRequest(req, &secret); err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+// Get a token for key vault from a service principal when one is configured, otherwise from the managed identity
+// of the machine. Returns the token and when it expires
+func keyVaultAccessToken() (string, time.Time, error) {
+	var req *http.Request
+	var err error
+	if config.KeyVault.ClientSecret != "" {
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {config.KeyVault.ClientId},
+			"client_secret": {config.KeyVault.ClientSecret},
+			"scope":         {"https://vault.azure.net/.default"},
+		}
+		req, err = http.NewRequest("POST", "https://login.microsoftonline.com/"+url.PathEscape(config.KeyVault.TenantId)+"/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := url.Values{"api-version": {"2018-02-01"}, "resource": {"https://vault.azure.net"}}
+		//A client id selects a user assigned identity when the machine has more than one
+		if config.KeyVault.ClientId != "" {
+			query.Set("client_id", config.KeyVault.ClientId)
+		}
+		req, err = http.NewRequest("GET", "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		req.Header.Set("Metadata", "true")
+	}
+
+	//The managed identity endpoint gives expires_in as a string, the token endpoint as a number
+	var token struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err = doJSONRequest(req, &token); err != nil {
+		return "", time.Time{}, err
+	}
+	seconds, _ := token.ExpiresIn.Int64()
+	return token.AccessToken, time.Now().Add(time.Duration(seconds) * time.Second), nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Write a small json file with the outcome of the run, so a scheduler wrapper or monitoring can check it without
+// reading the log
+func writeSummaryFile() error {
+	status := map[int]string{exitSuccess: "success", exitRemoved: "success", exitItemErrors: "errors",
+		exitFatal: "failed", exitTripped: "tripped"}[stats.exitCode()]
+	errorSummary := stats.Fatal
+	if errorSummary == "" {
+		errorSummary = stats.Tripped
+	}
+	if errorSummary == "" && len(stats.Errors) > 0 {
+		errorSummary = stats.Errors[0]
+	}
+
+	body, err := json.MarshalIndent(map[string]interface{}{
+		"status":          status,
+		"exitCode":        stats.exitCode(),
+		"runId":           runID,
+		"tenant":          tenantName,
+		"reportOnly":      reportOnly,
+		"start":           stats.Start,
+		"end":             stats.End,
+		"durationSeconds": stats.End.Sub(stats.Start).Round(time.Millisecond).Seconds(),
+		"sources":         stats.Sources,
+		"orphans":         stats.Orphans,
+		"exempt":          stats.Exempt,
+		"removed":         stats.Removed,
+		"removeFailed":    stats.RemoveFailed,
+		"added":           stats.Added,
+		"addFailed":       stats.AddFailed,
+		"errors":          len(stats.Errors),
+		"errorSummary":    errorSummary,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	//Replace the file in one step so a reader never sees it half written
+	path := configRelativePath(logFileName(config.SummaryFile, tenantName, stats.Start))
+	if err = os.WriteFile(path+".tmp", body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// When each directory source finished loading this run, the time a removed computer was last confirmed missing
+var sourcesRead = map[string]time.Time{}
+
+// The removal checks every computer removed this run passed
+var checksPassed []string
+
+// The pending entries of the computers removed once their delay was over, by normalized name
+var dueRemovals = map[string]pendingRemoval{}
+
+// The evidence a computer was removed on, one json line in the ledger per removal
+type ledgerEntry struct {
+	Time     time.Time `json:"time"`
+	RunId    string    `json:"runId"`
+	Tenant   string    `json:"tenant,omitempty"`
+	Computer string    `json:"computer"`
+	Branch   string    `json:"branch,omitempty"`
+	//The sources the computer was missing from, with how many computers each listed and when
+	AbsentFrom    []ledgerSource  `json:"absentFrom"`
+	FailedSources []string        `json:"failedSources,omitempty"`
+	Exemptions    int             `json:"exemptionsChecked"`
+	Rule          string          `json:"rule,omitempty"`
+	Checks        []string        `json:"checksPassed,omitempty"`
+	Pending       *pendingRemoval `json:"pending,omitempty"`
+	Evidence      []string        `json:"evidence"`
+}
+
+type ledgerSource struct {
+	Source    string    `json:"source"`
+	Computers int       `json:"computers"`
+	ReadAt    time.Time `json:"readAt"`
+}
+
+// The ledger file for the tenant being synced
+func ledgerPath() string {
+	return configRelativePath(logFileName(config.Ledger.File, tenantName, stats.Start))
+}
+
+// Gather why a computer was removed
+func ledgerEntryFor(name string, removedAt time.Time) ledgerEntry {
+	entry := ledgerEntry{Time: removedAt, RunId: runID, Tenant: tenantName, Computer: name, Branch: branchOf(name),
+		AbsentFrom: []ledgerSource{}, FailedSources: stats.FailedSources, Checks: checksPassed,
+		Exemptions: len(config.Database.ExemptComputers)}
+
+	sources := []string{}
+	for source := range sourcesRead {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		s := ledgerSource{Source: source, Computers: stats.Sources[source], ReadAt: sourcesRead[source]}
+		entry.AbsentFrom = append(entry.AbsentFrom, s)
+		entry.Evidence = append(entry.Evidence, fmt.Sprintf("not among the %d computers in %s as of %s", s.Computers,
+			source, s.ReadAt.Format(time.RFC3339)))
+	}
+	for _, source := range stats.FailedSources {
+		entry.Evidence = append(entry.Evidence, "not checked in "+source+", which failed to load")
+	}
+
+	_, rule := nameRule(name)
+	entry.Rule = rule
+	if rule != "" {
+		entry.Evidence = append(entry.Evidence, "eligible for removal by "+rule)
+	}
+	entry.Evidence = append(entry.Evidence, fmt.Sprintf("not exempt, checked against %d exemptions", entry.Exemptions))
+	for _, check := range checksPassed {
+		entry.Evidence = append(entry.Evidence, "passed the "+check+" check")
+	}
+
+	if p, ok := dueRemovals[normalize(name)]; ok {
+		entry.Pending = &p
+		if p.Approved {
+			entry.Evidence = append(entry.Evidence, "removal approved by "+p.By)
+		} else {
+			entry.Evidence = append(entry.Evidence, fmt.Sprintf("pending since %s, the removal delay ended %s",
+				p.Since.Format(time.RFC3339), p.Due.Format(time.RFC3339)))
+		}
+	}
+	return entry
+}
+
+// Append the evidence for a removal to the ledger. The ledger is only ever added to, so it can answer for a
+// deletion long after the run
+func recordLedger(name string, removedAt time.Time) {
+	if config.Ledger.File == "" || simulate {
+		return
+	}
+	data, err := json.Marshal(ledgerEntryFor(name, removedAt))
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(ledgerPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			_, err = f.Write(append(data, '\n'))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		stats.addError(fmt.Sprintf("Unable to record the removal of %s in the ledger: %s", name, err.Error()))
+		writeWarnFields("Unable to record the removal of "+name+" in the ledger: "+err.Error(), logFields{"computer": name})
+	}
+}
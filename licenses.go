@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// Record how many workstations Polaris holds once the changes of the run are made, against the licenses configured
+// for them. Nothing is recorded when the database wasn't read
+func countWorkstations() {
+	count, ok := stats.Sources["polaris"]
+	if !ok {
+		return
+	}
+	stats.Workstations = count - stats.Removed + stats.Added
+	stats.Licenses = config.Licenses.Workstations
+
+	//Checked on every run, a library can run out of licenses by adding computers without any being removed
+	threshold := config.Licenses.AlertPercent
+	if stats.Licenses > 0 && threshold > 0 && stats.licenseUsage() >= threshold {
+		stats.LicenseAlert = fmt.Sprintf("%.0f%% of the workstation licenses are in use, at or above the %g%% alert threshold",
+			stats.licenseUsage(), threshold)
+		writeWarnFields(stats.LicenseAlert, logFields{"workstations": stats.Workstations, "licenses": stats.Licenses})
+	}
+}
+
+// The share of the licensed workstations in use, as a percentage, or 0 when no license count is configured
+func (s *runStats) licenseUsage() float64 {
+	if s.Licenses <= 0 {
+		return 0
+	}
+	return float64(s.Workstations) * 100 / float64(s.Licenses)
+}
+
+// The workstation count for the summaries, with the license utilization when a license count is configured, e.g.
+// 950 of 1000 licensed (95%)
+func (s *runStats) workstationsText() string {
+	if s.Licenses <= 0 {
+		return fmt.Sprint(s.Workstations)
+	}
+	text := fmt.Sprintf("%d of %d licensed (%.0f%%)", s.Workstations, s.Licenses, s.licenseUsage())
+	if s.Workstations > s.Licenses {
+		text += fmt.Sprintf(", %d over the limit", s.Workstations-s.Licenses)
+	}
+	return text
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extra values attached to a log event, written as separate properties in the json format
+type logFields map[string]interface{}
+
+const (
+	levelError = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+// A destination for log events with its own level, such as the log file or the console
+type logSink struct {
+	level int
+	write func(level int, msg string, fields logFields)
+	//Releases the file or connection behind the sink, nil for the console
+	close func()
+}
+
+var (
+	logSinks []*logSink
+	runID    = newRunID()
+	//Deletes run in parallel, so events are written one at a time to keep lines whole
+	logLock sync.Mutex
+)
+
+// Each run gets a random id that appears in every log line and output, so the events of one run can be picked out
+// of a shared log. Tenant child processes are given the id of the run that started them
+func newRunID() string {
+	if id := os.Getenv("POLARISSYNC_RUN_ID"); id != "" {
+		return id
+	}
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func parseLevel(name string) int {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return i
+		}
+	}
+	return levelInfo
+}
+
+// Create a sink writing errors and warnings to one writer and everything else to another
+func newLogSink(level int, errOut io.Writer, out io.Writer) *logSink {
+	if config.Logging.Format == "json" {
+		//Console sinks use stdout for json so every event stays in one stream
+		enc := json.NewEncoder(out)
+		return &logSink{level: level, write: func(level int, msg string, fields logFields) {
+			writeJSONEvent(enc, levelNames[level], msg, fields)
+		}}
+	}
+	loggers := []*log.Logger{
+		log.New(errOut, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile),
+		log.New(errOut, "WARN: ", log.Ldate|log.Ltime),
+		log.New(out, "INFO: ", log.Ldate|log.Ltime),
+		log.New(out, "DEBUG: ", log.Ldate|log.Ltime),
+	}
+	return &logSink{level: level, write: func(level int, msg string, fields logFields) {
+		//Skip this function, writeLog and the write* wrapper so the error line shows the caller
+		loggers[level].Output(4, "["+runID+"] "+msg)
+	}}
+}
+
+// Open the log file for the day, appending if it already exists, and the console output. Each has its own level,
+// verbose forces both to debug. The sinks of an earlier call are closed first, the daemon sets up logging again for
+// every run
+func setupLogging(tenant string, verbose bool) error {
+	closeLogging()
+
+	if config.Logging.Enabled {
+		//generate a log file name based on the current date, create the file or append if it already exists
+		logfilename := logFileName(config.Logging.Filename, tenant, time.Now())
+		logFile, err := os.OpenFile(filepath.Join(config.Logging.Location, logfilename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		level := parseLevel(config.Logging.Level)
+		if verbose {
+			level = levelDebug
+		}
+		sink := newLogSink(level, logFile, logFile)
+		sink.close = func() { logFile.Close() }
+		logSinks = append(logSinks, sink)
+	}
+
+	if config.Logging.Console.Enabled {
+		level := parseLevel(config.Logging.Console.Level)
+		if verbose {
+			level = levelDebug
+		}
+		//Stdout is kept for the results when they are printed as json or csv
+		out := io.Writer(os.Stdout)
+		if outputFormat == "json" || outputFormat == "csv" {
+			out = os.Stderr
+		}
+		logSinks = append(logSinks, newLogSink(level, os.Stderr, out))
+	}
+
+	if config.Logging.Syslog.Enabled {
+		sink, err := newSyslogSink()
+		if err != nil {
+			return fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		if verbose {
+			sink.level = levelDebug
+		}
+		logSinks = append(logSinks, sink)
+	}
+	return nil
+}
+
+// Close the log file and syslog connection and stop writing to any sink
+func closeLogging() {
+	logLock.Lock()
+	defer logLock.Unlock()
+	for _, sink := range logSinks {
+		if sink.close != nil {
+			sink.close()
+		}
+	}
+	logSinks = nil
+}
+
+// Expand the log file name template. Dates use strftime style codes, which are always zero padded so the files sort
+// by date, and {tenant} becomes -name when a single tenant is being synced
+func logFileName(template string, tenant string, now time.Time) string {
+	if tenant != "" {
+		tenant = "-" + tenant
+	}
+	return strings.NewReplacer(
+		"{tenant}", tenant,
+		"%Y", now.Format("2006"),
+		"%m", now.Format("01"),
+		"%d", now.Format("02"),
+		"%H", now.Format("15"),
+		"%M", now.Format("04"),
+		"%S", now.Format("05"),
+		"%%", "%",
+	).Replace(template)
+}
+
+// Write one json object per line for log collectors such as Splunk or ELK
+func writeJSONEvent(enc *json.Encoder, level string, msg string, fields logFields) {
+	event := logFields{}
+	for k, v := range fields {
+		if s, ok := v.(string); ok {
+			v = redact(s)
+		}
+		event[k] = v
+	}
+	event["time"] = time.Now().Format(time.RFC3339)
+	event["level"] = level
+	event["runId"] = runID
+	event["message"] = redact(msg)
+	enc.Encode(event)
+}
+
+// Write a message to every sink whose level includes it
+func writeLog(level int, msg string, fields logFields) {
+	logLock.Lock()
+	defer logLock.Unlock()
+	for _, sink := range logSinks {
+		if level > sink.level {
+			continue
+		}
+		sink.write(level, redact(msg), fields)
+	}
+}
+
+func writeDebug(msg string) {
+	writeLog(levelDebug, msg, nil)
+}
+
+func writeDebugFields(msg string, fields logFields) {
+	writeLog(levelDebug, msg, fields)
+}
+
+func writeInfo(msg string) {
+	writeLog(levelInfo, msg, nil)
+}
+
+func writeInfoFields(msg string, fields logFields) {
+	writeLog(levelInfo, msg, fields)
+}
+
+func writeWarn(msg string) {
+	writeLog(levelWarn, msg, nil)
+}
+
+func writeWarnFields(msg string, fields logFields) {
+	writeLog(levelWarn, msg, fields)
+}
+
+// Log the error that stopped a run
+func writeError(err error) {
+	writeLog(levelError, err.Error(), nil)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/venutios/polarissync/polarisdb"
+	"github.com/venutios/polarissync/sources/ad"
+	"github.com/venutios/polarissync/sources/azure"
+	"github.com/venutios/polarissync/syncengine"
+)
+
+// Print what Polaris and each enabled directory hold about one computer, without running a sync
+func runLookupCommand(args []string) int {
+	fs := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: polarissync lookup [flags] <computer>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	if *cf.tenant == "" && len(config.Tenants) > 0 {
+		fmt.Fprintln(os.Stderr, "Select the tenant to look in with -tenant")
+		return exitUsage
+	}
+
+	name := strings.TrimSpace(fs.Arg(0))
+	type system struct {
+		title  string
+		lookup func(string) ([][][2]string, error)
+	}
+	systems := []system{{"Polaris", lookupPolaris}}
+	if config.ActiveDirectory.Enabled {
+		systems = append(systems, system{"Active Directory", lookupAD})
+	}
+	if config.Azure.Enabled {
+		systems = append(systems, system{"Azure AD", lookupAzure})
+	}
+
+	code := exitSuccess
+	for _, system := range systems {
+		fmt.Println(system.title)
+		records, err := system.lookup(name)
+		switch {
+		case err != nil:
+			fmt.Println("  Lookup failed: " + redact(err.Error()))
+			code = exitItemErrors
+		case len(records) == 0:
+			fmt.Println("  Not found")
+		}
+		for i, record := range records {
+			if i > 0 {
+				fmt.Println()
+			}
+			for _, field := range record {
+				fmt.Printf("  %-17s %s\n", field[0]+":", field[1])
+			}
+		}
+		fmt.Println()
+	}
+	return code
+}
+
+// The workstations in Polaris with the name
+func lookupPolaris(name string) ([][][2]string, error) {
+	db, err := openDatabase(false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	sqlDB, ok := db.(*polarisdb.SQL)
+	if !ok {
+		names, err := db.Workstations()
+		return listedRecords(name, names), err
+	}
+	workstations, err := sqlDB.Lookup(name)
+	records := [][][2]string{}
+	for _, w := range workstations {
+		records = append(records, [][2]string{
+			{"Workstation ID", strconv.FormatInt(w.WorkstationID, 10)},
+			{"Computer name", w.ComputerName},
+			{"Display name", w.DisplayName},
+			{"Branch", strings.TrimSpace(w.Abbreviation + " " + w.Branch)},
+			{"Enabled", yesNo(w.Enabled)},
+			{"Created", formatLookupTime(w.Created)},
+		})
+	}
+	return records, err
+}
+
+// The computer objects in AD with the name
+func lookupAD(name string) ([][][2]string, error) {
+	directory, err := openDirectory("activedirectory.backend", config.ActiveDirectory.Backend, config.ActiveDirectory.Fixture)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := directory.(ad.Directory)
+	if !ok {
+		return listedDirectory(name, directory)
+	}
+	computers, err := ad.Lookup(d.Options, name)
+	records := [][][2]string{}
+	for _, c := range computers {
+		records = append(records, [][2]string{
+			{"DN", c.DN},
+			{"DNS name", c.DNSHostName},
+			{"Operating system", c.OperatingSystem},
+			{"Enabled", yesNo(!c.Disabled)},
+			{"Last logon", formatLookupTime(c.LastLogon)},
+			{"Created", formatLookupTime(c.Created)},
+		})
+	}
+	return records, err
+}
+
+// The Azure AD devices with the name
+func lookupAzure(name string) ([][][2]string, error) {
+	directory, err := openDirectory("azure.backend", config.Azure.Backend, config.Azure.Fixture)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := directory.(azure.Directory)
+	if !ok {
+		return listedDirectory(name, directory)
+	}
+	devices, err := azure.Lookup(d.Options, name)
+	records := [][][2]string{}
+	for _, device := range devices {
+		records = append(records, [][2]string{
+			{"Display name", device.DisplayName},
+			{"Device ID", device.DeviceID},
+			{"Trust type", device.TrustType},
+			{"Profile type", device.ProfileType},
+			{"Operating system", device.OSType},
+			{"Enabled", device.Enabled},
+			{"Last logon", device.LastLogon},
+		})
+	}
+	return records, err
+}
+
+// Look for the name in everything a directory without lookups lists, such as a fixture
+func listedDirectory(name string, directory syncengine.Directory) ([][][2]string, error) {
+	names := []string{}
+	_, err := directory.Computers(func(n string) {
+		names = append(names, n)
+	})
+	return listedRecords(name, names), err
+}
+
+// A record for each of the names matching name, when all that is known is that the computer is listed
+func listedRecords(name string, names []string) [][][2]string {
+	records := [][][2]string{}
+	for _, n := range names {
+		if normalize(n) == normalize(name) {
+			records = append(records, [][2]string{{"Name", strings.TrimSpace(n)}})
+		}
+	}
+	return records
+}
+
+func formatLookupTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
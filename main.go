@@ -1,365 +1,533 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
-	"io"
-	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"strings"
-	"time"
 
 	_ "github.com/denisenkom/go-mssqldb"
 	"github.com/go-ldap/ldap/v3"
-	"github.com/spf13/viper"
+	cfg "github.com/venutios/polarissync/config"
+	"github.com/venutios/polarissync/polarisdb"
+	"github.com/venutios/polarissync/sources/ad"
+	"github.com/venutios/polarissync/sources/azure"
+	"github.com/venutios/polarissync/syncengine"
 )
 
-type Organization struct {
-	OrganizationID int
-	Abbreviation   string
-}
-
 var (
-	config          Configuration
+	config          cfg.Configuration
 	dbComputers     []string
-	adComputers     []string
-	dbOrganizations []Organization
-	logFile         *os.File
-	errorLogger     *log.Logger
-	infoLogger      *log.Logger
+	dbOrganizations []polarisdb.Organization
+	//Set by the report command, nothing is written to the database
+	reportOnly bool
+	//Set by the simulate command. The run goes through every step but the database is never changed and nothing
+	//is recorded or sent that could be taken for a real run
+	simulate bool
 )
 
 func main() {
-	viper.SetConfigName("config")
-	viper.SetConfigType("json")
-	viper.AddConfigPath(".")
-
-	viper.SetDefault("logging.enabled", false)
-	viper.SetDefault("logging.location", ".")
-	viper.SetDefault("azure.enabled", false)
-	viper.SetDefault("activedirectory.enabled", true)
-	viper.SetDefault("activedirectory.host", "127.0.0.1")
-	viper.SetDefault("database.host", "127.0.0.1")
-	viper.SetDefault("database.port", 1433)
-	viper.SetDefault("database.trusted", true)
-	viper.SetDefault("database.exemptComputers", []string{})
-
-	err := viper.ReadInConfig()
-	if err != nil {
-		panic(fmt.Errorf("unable to read config file: %w", err))
-	}
+	os.Exit(runCommand(os.Args[1:]))
+}
 
-	err = viper.Unmarshal(&config)
-	if err != nil {
-		panic(fmt.Errorf("config file is corrupt: %w", err))
-	}
+// Load the computers from each source and bring the database in line with them. Returns the error that stopped the
+// run, which has already been logged and recorded in the run summary
+func runSync() (err error) {
+	stats.Start = clock.Now()
+	recordRunStarted()
+	stack := ""
+	defer func() {
+		//A panic is a bug, the run still ends with its summary written and the fatal exit code
+		if r := recover(); r != nil {
+			stack = string(debug.Stack())
+			err = fmt.Errorf("%v", r)
+			writeLog(levelError, err.Error()+"\n"+stack, nil)
+		} else if err != nil {
+			writeError(err)
+		}
+		finishRun(err, stack)
+	}()
 
-	if config.Logging.Enabled {
-		//generate a log file name based on the current date, create the file or append if it already exists
-		now := time.Now()
-		logfilename := "polarissync" + strconv.Itoa(now.Year()) + strconv.Itoa(int(now.Month())) + strconv.Itoa(now.Day()) + ".log"
-		logFile, err = os.OpenFile(filepath.Join(config.Logging.Location, logfilename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	//A report or simulation changes nothing, so it can run alongside a sync
+	if !reportOnly && !simulate {
+		release, err := acquireRunLock()
 		if err != nil {
-			panic(fmt.Errorf("failed to open log file: %w", err))
+			return err
 		}
-		errorLogger = log.New(logFile, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
-		infoLogger = log.New(logFile, "INFO: ", log.Ldate|log.Ltime)
+		defer release()
 	}
 
+	writeInfo("Starting run " + runID + " with " + versionString())
+	if simulate {
+		writeInfo("Simulating, the database will not be changed")
+	}
+	if err = runHook("preRun", config.Hooks.PreRun, hookContext{}); err != nil {
+		return err
+	}
 	writeInfo("Loading the list of organizations from the database")
-	listDBOrganizations()
+	if err = listDBOrganizations(); err != nil {
+		return err
+	}
+	if err = checkInterrupted(); err != nil {
+		return err
+	}
 	writeInfo("Loading the list of computers from the database")
-	listDBComputers()
+	if err = listDBComputers(); err != nil {
+		return err
+	}
 	if config.ActiveDirectory.Enabled {
+		if err = checkInterrupted(); err != nil {
+			return err
+		}
 		writeInfo("Loading the list of computers from Active Directory")
-		listADComputers()
+		if err = loadSource("ad", listADComputers); err != nil {
+			return err
+		}
 	}
 	if config.Azure.Enabled {
+		if err = checkInterrupted(); err != nil {
+			return err
+		}
 		writeInfo("Loading the list of computers from Azure")
-		listAzureComputers()
+		if err = loadSource("azure", listAzureComputers); err != nil {
+			return err
+		}
+	}
+	if len(stats.FailedSources) > 0 && len(stats.FailedSources) == len(enabledSources()) {
+		return fmt.Errorf("every directory source failed, nothing to compare with")
 	}
+	if err = checkInterrupted(); err != nil {
+		return err
+	}
+	if err = findReappeared(); err != nil {
+		writeWarn(err.Error())
+	}
+	findStaleExemptions()
 	writeInfo("Searching for computers to remove from the database")
-	findComputersToRemoveFromDB()
+	if err = findComputersToRemoveFromDB(); err != nil {
+		return err
+	}
+	if err = checkInterrupted(); err != nil {
+		return err
+	}
 	writeInfo("Searching for computers to add to the database")
-	findComputersToAddToDB()
+	if err = findComputersToAddToDB(); err != nil {
+		return err
+	}
+	recordIdentities()
+	return nil
 }
 
-func writeInfo(msg string) {
-	if infoLogger != nil {
-		infoLogger.Println(msg)
+// Load the computers from the database and each enabled directory source, for the commands that compare them
+// without syncing
+func loadComputers() error {
+	if err := listDBOrganizations(); err != nil {
+		return err
 	}
+	if err := listDBComputers(); err != nil {
+		return err
+	}
+	if config.ActiveDirectory.Enabled {
+		if err := listADComputers(); err != nil {
+			return err
+		}
+	}
+	if config.Azure.Enabled {
+		if err := listAzureComputers(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func writeError(err error) {
-	if errorLogger != nil {
-		errorLogger.Panic(err)
-	}
-	panic(err)
+// Apply a read or write override on top of the main database settings
+func mergeConnection(override cfg.DatabaseConnection) cfg.DatabaseConnection {
+	db := cfg.DatabaseConnection{
+		Host:     config.Database.Host,
+		Port:     config.Database.Port,
+		Name:     config.Database.Name,
+		Trusted:  config.Database.Trusted,
+		Domain:   config.Database.Domain,
+		Username: config.Database.Username,
+		Password: config.Database.Password,
+	}
+	if override.Host != "" {
+		db.Host = override.Host
+	}
+	if override.Port != 0 {
+		db.Port = override.Port
+	}
+	if override.Name != "" {
+		db.Name = override.Name
+	}
+	//Credentials are replaced as a set so a read-only account never inherits the password of the main account
+	if override.Trusted {
+		db.Trusted = true
+	} else if override.Username != "" {
+		db.Trusted = false
+		db.Domain = override.Domain
+		db.Username = override.Username
+		db.Password = override.Password
+	}
+	return db
 }
 
-// Build the database connection string based on the config of a trusted connection, or specifying credentials
-func buildConnString() string {
-	if config.Database.Trusted {
-		return fmt.Sprintf("server=%s;port=%d;database=%s;trusted_connection=yes", config.Database.Host, config.Database.Port, config.Database.Name)
-	} else {
-		username := config.Database.Domain + "\\" + config.Database.Username
-		return fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d;database=%s", config.Database.Host, username, config.Database.Password, config.Database.Port, config.Database.Name)
-	}
+// Connection string used when listing workstations and organizations
+func readConnString() string {
+	conn := polarisdb.ConnString(mergeConnection(config.Database.Read))
+	writeDebug("Read connection: " + conn)
+	return conn
+}
+
+// Connection string used when adding or removing workstations
+func writeConnString() string {
+	conn := polarisdb.ConnString(mergeConnection(config.Database.Write))
+	writeDebug("Write connection: " + conn)
+	return conn
 }
 
 // Populate the dbComputers slice with a list of computers names
-func listDBComputers() {
-	conn, err := sql.Open("mssql", buildConnString())
-	if err != nil {
-		writeError(fmt.Errorf("database connection failed: %w", err))
-	}
-	defer conn.Close()
+func listDBComputers() error {
+	defer startSpan("load polaris workstations").finish()
+	var names []string
+	err := withRetry("Loading workstations", func() error {
+		db, err := openDatabase(false)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
 
-	rows, err := conn.Query("select ComputerName from Polaris.Workstations where ComputerName is not null")
+		names, err = db.Workstations()
+		return err
+	})
 	if err != nil {
-		writeError(fmt.Errorf("failed to load workstations: %w", err))
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var ComputerName string
-		if err := rows.Scan(&ComputerName); err != nil {
-			writeError(fmt.Errorf("error reading record from database: %w", err))
-		}
-		dbComputers = append(dbComputers, strings.ToUpper(ComputerName))
+		return err
 	}
-	if err = rows.Err(); err != nil {
-		writeError(fmt.Errorf("error reading from database: %w", err))
+	//Names are kept as stored so changes to a workstation match its record, the matcher compares them normalized
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		dbComputers = append(dbComputers, name)
+		addComputerSource(normalize(name), "polaris")
 	}
+	matcher = syncengine.NewMatcher(dbComputers, nameRules())
 
-	writeInfo(strconv.Itoa(len(dbComputers)) + " records retrieved")
+	stats.Sources["polaris"] = len(dbComputers)
+	writeInfoFields(strconv.Itoa(len(dbComputers))+" records retrieved", logFields{"source": "polaris", "count": len(dbComputers)})
+	return nil
 }
 
-// Populate the adComputers slice with a list of computers names
-func listADComputers() {
-	l, err := ldap.DialURL(fmt.Sprintf("ldap://%s:389", config.ActiveDirectory.Host))
-	if err != nil {
-		writeError(fmt.Errorf("unable to connect to AD server: %w", err))
+// Where to find Active Directory, from the config
+func adOptions() ad.Options {
+	return ad.Options{
+		Host:             config.ActiveDirectory.Host,
+		Trusted:          config.ActiveDirectory.Trusted,
+		Domain:           config.ActiveDirectory.Domain,
+		Username:         config.ActiveDirectory.Username,
+		Password:         config.ActiveDirectory.Password,
+		BaseDN:           config.ActiveDirectory.Dn,
+		PageSize:         config.ActiveDirectory.PageSize,
+		Attribute:        config.ActiveDirectory.KeyAttribute,
+		DeletedObjectsDN: config.ActiveDirectory.DeletedObjectsDn,
 	}
-	defer l.Close()
-
-	username := config.ActiveDirectory.Domain + "\\" + config.ActiveDirectory.Username
+}
 
-	if err := l.Bind(username, config.ActiveDirectory.Password); err != nil {
-		writeError(fmt.Errorf("unable to bind to ldap: %w", err))
-	}
+// Open a connection to the AD server and bind with the configured account
+func connectLDAP() (*ldap.Conn, error) {
+	return ad.Connect(adOptions())
+}
 
-	//Retrieve only the cn attribute for all computer objects
-	searhReq := ldap.NewSearchRequest(config.ActiveDirectory.Dn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, "(&(objectClass=computer))", []string{"cn"}, nil)
+// Read the computers in Active Directory a page at a time, comparing each with the database as it arrives
+func listADComputers() error {
+	defer startSpan("load active directory computers").finish()
+	writeDebug(fmt.Sprintf("LDAP search of %s for computer objects", config.ActiveDirectory.Dn))
 
-	result, err := l.Search(searhReq)
+	directory, err := openDirectory("activedirectory.backend", config.ActiveDirectory.Backend, config.ActiveDirectory.Fixture)
 	if err != nil {
-		writeError(fmt.Errorf("ldap search error: %w", err))
+		return err
 	}
-
-	if len(result.Entries) > 0 {
-		for _, x := range result.Entries {
-			adComputers = append(adComputers, strings.ToUpper(x.Attributes[0].Values[0]))
+	count := 0
+	err = withRetry("LDAP search", func() (err error) {
+		loaded := newProgress("Loading computers from AD", 0)
+		//The objectGUID of each computer is kept to notice renames
+		if identified, ok := directory.(syncengine.IdentifiedDirectory); ok {
+			count, err = identified.IdentifiedComputers(func(name string, id string) {
+				matchDirectoryComputer(name, "ad")
+				if id != "" {
+					directoryIDs[id] = normalize(name)
+				}
+				loaded.add(1)
+			})
+			return err
 		}
-	} else {
-		writeError(fmt.Errorf("no results returned from ldap search"))
+		count, err = directory.Computers(func(name string) {
+			matchDirectoryComputer(name, "ad")
+			loaded.add(1)
+		})
+		return err
+	})
+	if err != nil {
+		return err
 	}
+	writeDebugFields(fmt.Sprintf("LDAP search returned %d entries", count), logFields{"source": "ad", "count": count})
 
-	writeInfo(strconv.Itoa(len(adComputers)) + " records retrieved from AD")
+	if count == 0 {
+		return fmt.Errorf("no results returned from ldap search")
+	}
+
+	stats.Sources["ad"] = count
+	writeInfoFields(strconv.Itoa(count)+" records retrieved from AD", logFields{"source": "ad", "count": count})
+	return nil
 }
 
-// Add records for Azure joined machine to the adComputers slice
-func listAzureComputers() {
-	cmd := exec.Command("powershell", "-nologo", "-noprofile")
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		log.Fatal(err)
+// The account used to sign in to Azure AD, from the config
+func azureOptions() azure.Options {
+	return azure.Options{
+		Username: config.ActiveDirectory.Username,
+		Password: config.ActiveDirectory.Password,
+		Domain:   config.Azure.Domain,
+		Property: config.Azure.KeyProperty,
 	}
-	stderr, err := cmd.StderrPipe()
+}
+
+// Start powershell, sign in to Azure AD and run the given commands, returning everything written to stdout
+func runAzurePowershell(commands ...string) ([]byte, error) {
+	out, err := azure.Output(azureOptions(), commands...)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("%s", redact(err.Error()))
 	}
-	stdout, err := cmd.StdoutPipe()
+	return out, nil
+}
+
+// Read the Azure joined machines as powershell lists them, comparing each with the database as it arrives
+func listAzureComputers() error {
+	defer startSpan("load azure devices").finish()
+	directory, err := openDirectory("azure.backend", config.Azure.Backend, config.Azure.Fixture)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	go func() {
-		defer stdin.Close()
-		fmt.Fprintln(stdin, "$userName = '"+config.ActiveDirectory.Username+"@"+config.Azure.Domain+"'")
-		fmt.Fprintln(stdin, "$passText = '"+config.ActiveDirectory.Password+"'")
-		fmt.Fprintln(stdin, "$secpasswd = ConvertTo-SecureString -String $passText -AsPlainText -Force")
-		fmt.Fprintln(stdin, "$creds = New-Object System.Management.Automation.PSCredential ($userName, $secpasswd)")
-		fmt.Fprintln(stdin, "Connect-AzureAD -Credential $creds")
-		fmt.Fprintln(stdin, "Get-AzureADDevice -All $true | Where {($_.DeviceTrustType -eq \"AzureAD\") -and ($_.ProfileType -eq \"RegisteredDevice\")} | Format-Table -Property DisplayName")
-	}()
-
-	if err = cmd.Start(); err != nil {
-		writeError(fmt.Errorf("failed to connect to powershell: %w", err))
+	count := 0
+	err = withRetry("Loading Azure devices", func() (err error) {
+		loaded := newProgress("Loading devices from Azure", 0)
+		count, err = directory.Computers(func(name string) {
+			matchDirectoryComputer(name, "azure")
+			loaded.add(1)
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retrieve records from Azure: %w", err)
 	}
 
-	out, _ := io.ReadAll(stdout)
-	errtxt, _ := io.ReadAll(stderr)
-
-	if err = cmd.Wait(); err != nil {
-		writeError(fmt.Errorf("failed to retrieve records from Azure: %w\n%s", err, errtxt))
-	}
+	stats.Sources["azure"] = count
+	writeInfoFields(strconv.Itoa(count)+" records retrieved from Azure", logFields{"source": "azure", "count": count})
+	return nil
+}
 
-	//parse the powershell output
-	psData := strings.Split(string(out[:]), "\r")
-	skip := true
+// Looking for items in dbComputers that weren't found in a directory source and aren't exempt in the config
+func findComputersToRemoveFromDB() error {
+	defer startSpan("find computers to remove").finish()
 	count := 0
-	for _, c := range psData {
-		//Start of the computer records has been found, save each line until a blank line is encountered
-		if !skip {
-			trimmed := strings.TrimSpace((c))
-			if trimmed == "" {
-				break
-			}
-			adComputers = append(adComputers, strings.ToUpper(trimmed))
-			count++
+	orphans := []string{}
+	//Computers a name rule ignores are left out of the comparison, those it exempts are compared like the exempt list
+	database := []string{}
+	exemptions := append([]string{}, config.Database.ExemptComputers...)
+	exemptRules := map[string]string{}
+	renames := []rename{}
+	stored, err := storedRenames(tenantName)
+	if err != nil {
+		return err
+	}
+	detected := detectedRenames()
+	for _, name := range dbComputers {
+		policy, rule := nameRule(name)
+		switch policy {
+		case policyIgnore:
+			recordDecision(name, "ignore", "matches "+rule)
+			writeDebugFields("Ignoring "+name+", it matches "+rule, logFields{"computer": name, "action": "ignore"})
+			continue
+		case policyExempt:
+			exemptions = append(exemptions, name)
+			exemptRules[name] = rule
+		}
+		//A renamed computer keeps its workstation rather than losing it and being added again
+		if r, ok := renamedTo(name, stored, detected); ok {
+			renames = append(renames, r)
+			continue
+		}
+		database = append(database, name)
+	}
+	comparison := matcher.Compare(database, exemptions)
+	for _, name := range comparison.Keep {
+		writeDebugFields(name+" found in the directory, keeping", logFields{"computer": name, "action": "keep"})
+		recordDecision(name, "keep", "found in the directory")
+	}
+	for _, name := range comparison.Exempt {
+		stats.Exempt++
+		stats.ExemptComputers = append(stats.ExemptComputers, name)
+		if rule, ok := exemptRules[name]; ok {
+			recordDecision(name, "exempt", "matches "+rule)
 		} else {
-			//Check if this line is the dashes right above the list of computers
-			if strings.HasPrefix(strings.TrimSpace(c), "-----------") {
-				skip = false
-			}
+			recordDecision(name, "exempt", "in the exempt computers list")
 		}
+		writeInfoFields("Skipping "+name+", exempt from removal", logFields{"computer": name, "action": "exempt"})
+	}
+	for _, name := range comparison.Orphaned {
+		stats.Orphans++
+		writeDebugFields(name+" not found in any source and not exempt", logFields{"computer": name, "action": "orphan"})
+		orphans = append(orphans, name)
+		stats.OrphanComputers = append(stats.OrphanComputers, name)
 	}
 
-	writeInfo(strconv.Itoa(count) + " records retrieved from Azure")
-}
-
-// Looking for items in dcComputers that don't exist in adComputers and aren't exempt in the config
-func findComputersToRemoveFromDB() {
-	count := 0
-	for x := range dbComputers {
-		found := false
-		for y := range adComputers {
-			if dbComputers[x] == adComputers[y] {
-				found = true
-				break
-			}
+	if reportOnly {
+		orphans = applyRemovalChecks(orphans)
+		for _, name := range orphans {
+			recordDecision(name, "would remove", "not found in any source")
 		}
-
-		if !found {
-			for y := range config.Database.ExemptComputers {
-				if dbComputers[x] == config.Database.ExemptComputers[y] {
-					found = true
-					writeInfo("Skipping " + dbComputers[x] + ", exempt from removal")
-					break
-				}
-			}
+		writeInfoFields(strconv.Itoa(len(orphans))+" computers would be removed from database", logFields{"action": "remove", "count": len(orphans)})
+		return renameComputers(renames)
+	}
+
+	//Far more orphans than usual, or a source returning far fewer computers than usual, points to a problem with a
+	//source rather than real decommissions. The limits apply to every orphan, before the checks hold any back, so
+	//computers held for review don't hide that far too many went missing
+	//A partial listing can't show which computers are gone, those only in the failed source would all be removed
+	if len(stats.FailedSources) > 0 && len(orphans) > 0 {
+		stats.Tripped = fmt.Sprintf("%s failed to load, so the computers only found there would look orphaned", strings.Join(stats.FailedSources, " and "))
+	} else if config.Safety.MaxRemovals > 0 && len(orphans) > config.Safety.MaxRemovals {
+		stats.Tripped = fmt.Sprintf("%d computers to remove is more than the limit of %d", len(orphans), config.Safety.MaxRemovals)
+	} else if len(orphans) > 0 {
+		reason, err := checkSourceCounts()
+		if err != nil {
+			return err
 		}
-
-		if !found {
-			if removeComputer(dbComputers[x]) {
-				count++
-			}
+		stats.Tripped = reason
+	}
+	if stats.Tripped != "" {
+		writeWarnFields("Not removing any computers, "+stats.Tripped, logFields{"action": "remove", "count": len(orphans)})
+		for _, name := range orphans {
+			recordDecision(name, "skip", stats.Tripped)
+		}
+		//A tripped run leaves the database as it is, renames included
+		for _, r := range renames {
+			recordDecision(r.oldName, "skip", stats.Tripped)
 		}
+		return nil
 	}
 
-	writeInfo(strconv.Itoa(count) + " computers removed from database")
-}
+	//Only worth checking each computer once the removals as a whole can go ahead
+	orphans = applyRemovalChecks(orphans)
 
-// Remove the record from the database
-func removeComputer(name string) bool {
-	conn, err := sql.Open("mssql", buildConnString())
-	if err != nil {
-		writeError(fmt.Errorf("database connection failed: %w", err))
+	if config.Removals.Delay > 0 {
+		if orphans, err = stagePendingRemovals(orphans); err != nil {
+			return err
+		}
 	}
-	defer conn.Close()
 
-	_, err = conn.Exec("delete from Polaris.Workstations where ComputerName = ?", name)
-	if err != nil {
-		writeInfo(fmt.Sprintf("Failed to remove workstion %s: %s", name, err.Error()))
-		return false
-	} else {
-		writeInfo(name + " removed from database")
+	if err := renameComputers(renames); err != nil {
+		return err
 	}
 
-	return true
-}
-
-// Populate the dbOrganizations slice with a list of organization IDs and codes
-func listDBOrganizations() {
-	conn, err := sql.Open("mssql", buildConnString())
-	if err != nil {
-		writeError(fmt.Errorf("database connection failed: %w", err))
+	if err := backupWorkstations(orphans); err != nil {
+		return err
 	}
-	defer conn.Close()
-
-	rows, err := conn.Query("select OrganizationID, Abbreviation from Polaris.Organizations")
+	count, err = removeComputers(orphans)
+	stats.Removed = count
 	if err != nil {
-		writeError(fmt.Errorf("failed to load organizations: %w", err))
+		return err
 	}
-	defer rows.Close()
+	if err = checkInterrupted(); err != nil {
+		return err
+	}
+	writeInfoFields(strconv.Itoa(count)+" computers removed from database", logFields{"action": "remove", "count": count})
+	return nil
+}
 
-	for rows.Next() {
-		var Org Organization
-		if err := rows.Scan(&Org.OrganizationID, &Org.Abbreviation); err != nil {
-			writeError(fmt.Errorf("error reading record from database: %w", err))
+// Populate the dbOrganizations slice with a list of organization IDs and codes
+func listDBOrganizations() error {
+	defer startSpan("load polaris organizations").finish()
+	var orgs []polarisdb.Organization
+	err := withRetry("Loading organizations", func() error {
+		db, err := openDatabase(false)
+		if err != nil {
+			return err
 		}
-		Org.Abbreviation = strings.ToUpper(Org.Abbreviation)
-		dbOrganizations = append(dbOrganizations, Org)
-	}
-	if err = rows.Err(); err != nil {
-		writeError(fmt.Errorf("error reading from database: %w", err))
+		defer db.Close()
+
+		orgs, err = db.Organizations()
+		return err
+	})
+	if err != nil {
+		return err
 	}
+	dbOrganizations = append(dbOrganizations, orgs...)
 
-	writeInfo(strconv.Itoa(len(dbOrganizations)) + " records retrieved")
+	writeInfoFields(strconv.Itoa(len(dbOrganizations))+" records retrieved", logFields{"source": "organizations", "count": len(dbOrganizations)})
+	return nil
 }
 
-func findComputersToAddToDB() {
+func findComputersToAddToDB() error {
+	defer startSpan("find computers to add").finish()
 	count := 0
-	for x := range adComputers {
-		found := false
-		for y := range dbComputers {
-			if adComputers[x] == dbComputers[y] {
-				found = true
-				break
-			}
+	var db syncengine.Database
+	if !reportOnly && len(matcher.New()) > 0 {
+		var err error
+		if db, err = openDatabase(true); err != nil {
+			return err
 		}
-
-		if !found {
-			if addComputer(adComputers[x]) {
-				count++
-			}
+		defer db.Close()
+	}
+	added := newProgress("Adding computers", len(matcher.New()))
+	for _, name := range matcher.New() {
+		added.add(1)
+		if policy, rule := nameRule(name); policy == policyIgnore {
+			recordDecision(name, "ignore", "matches "+rule)
+			writeDebugFields("Ignoring "+name+", it matches "+rule, logFields{"computer": name, "action": "ignore"})
+			continue
+		}
+		if holdReappeared(name) {
+			continue
+		}
+		writeDebugFields(name+" not found in the database", logFields{"computer": name, "action": "new"})
+		if reportOnly {
+			recordDecision(name, "would add", "not found in the database")
+			continue
+		}
+		if err := checkInterrupted(); err != nil {
+			return err
+		}
+		if addComputer(db, name) {
+			count++
+			stats.Added = count
 		}
 	}
 
-	writeInfo(strconv.Itoa(count) + " computers added to database")
+	stats.Added = count
+	writeInfoFields(strconv.Itoa(count)+" computers added to database", logFields{"action": "add", "count": count})
+	return nil
 }
 
 // Add the record to the database
-func addComputer(name string) bool {
-	conn, err := sql.Open("mssql", buildConnString())
-	if err != nil {
-		writeError(fmt.Errorf("database connection failed: %w", err))
-	}
-	defer conn.Close()
-
-	orgID := 1
-	for i := range dbOrganizations {
-		if dbOrganizations[i].Abbreviation == name[0:2] {
-			orgID = dbOrganizations[i].OrganizationID
-		}
-	}
+func addComputer(db syncengine.Database, name string) bool {
+	span := startSpan("add workstation", "computer", name)
+	defer span.finish()
 
 	//For Polaris 7.5, add workstations to a workstations group. Retrieve the new ID
-	var workstationID int64
-	err = conn.QueryRow("insert into Polaris.Workstations(OrganizationID,DisplayName,ComputerName,CreatorID,CreationDate,Enabled,Status,LeapAllowed,TerminalServer) output inserted.WorkstationID values (?,?,?,?,GETDATE(),?,?,?,?)", orgID, name, name, 1, 1, 0, 1, 0).Scan(&workstationID)
+	workstationID, err := db.AddWorkstation(polarisdb.OrganizationFor(dbOrganizations, name), name)
 	if err != nil {
-		writeInfo(fmt.Sprintf("Failed to add workstation %s: %s", name, err.Error()))
+		span.fail(err.Error())
+		stats.AddFailed++
+		stats.addError(fmt.Sprintf("Failed to add workstation %s: %s", name, err.Error()))
+		recordDecision(name, "add failed", err.Error())
+		writeWarnFields(fmt.Sprintf("Failed to add workstation %s: %s", name, err.Error()), logFields{"computer": name, "action": "add", "error": err.Error()})
 		return false
 	} else {
-		writeInfo(name + " added to database")
+		stats.AddedComputers = append(stats.AddedComputers, name)
+		recordDecision(name, "add", "not found in the database")
+		writeInfoFields(name+" added to database", logFields{"computer": name, "action": "add"})
 
-		_, err := conn.Exec("insert into Polaris.GroupWorkstations(GroupID, WorkstationID) values (?,?)", 1, workstationID)
-		if err != nil {
-			writeInfo(fmt.Sprintf("Failed to add workstation %s with id %d to group: %s", name, workstationID, err.Error()))
+		if err := db.AddToGroup(1, workstationID); err != nil {
+			writeWarn(fmt.Sprintf("Failed to add workstation %s with id %d to group: %s", name, workstationID, err.Error()))
 		} else {
 			writeInfo(fmt.Sprintf("%s with id %d added to group workstations", name, workstationID))
 		}
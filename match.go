@@ -0,0 +1,24 @@
+package main
+
+import "github.com/venutios/polarissync/syncengine"
+
+// Compares the directory with the database as the sources are read
+var matcher = syncengine.NewMatcher(nil, syncengine.NameRules{})
+
+// The rules from the config for normalizing computer names before they are compared
+func nameRules() syncengine.NameRules {
+	return syncengine.NameRules{StripDnsSuffix: config.Matching.StripDnsSuffix,
+		NetbiosNames: config.Matching.NetbiosNames, StripDiacritics: config.Matching.StripDiacritics}
+}
+
+// The form of a computer name used to compare sources, following the rules in the config
+func normalize(name string) string {
+	return nameRules().Normalize(name)
+}
+
+// Compare a computer from a directory source with the database as soon as it is read
+func matchDirectoryComputer(name string, source string) {
+	for _, name := range matcher.Add(name) {
+		addComputerSource(name, source)
+	}
+}
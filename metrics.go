@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Write the results of the run in the prometheus text format. Labels, e.g. tenant="abc", are added to every series
+func writePrometheusMetrics(w io.Writer, labels string) {
+	gauge := func(name string, help string, value interface{}) {
+		series := name
+		if labels != "" {
+			series += "{" + labels + "}"
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, series, value)
+	}
+	sourceLabels := ""
+	if labels != "" {
+		sourceLabels = labels + ","
+	}
+
+	sources := []string{}
+	for source := range stats.Sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	fmt.Fprintf(w, "# HELP polarissync_source_computers Computers loaded from each source\n# TYPE polarissync_source_computers gauge\n")
+	for _, source := range sources {
+		fmt.Fprintf(w, "polarissync_source_computers{%ssource=%q} %d\n", sourceLabels, source, stats.Sources[source])
+	}
+
+	success := 1
+	errors := len(stats.Errors)
+	if stats.failed() {
+		success = 0
+		errors++
+	}
+	gauge("polarissync_orphans_found", "Workstations not found in any source", stats.Orphans)
+	gauge("polarissync_computers_exempt", "Orphaned workstations skipped because they are exempt", stats.Exempt)
+	gauge("polarissync_computers_removed", "Workstations removed from Polaris", stats.Removed)
+	gauge("polarissync_computers_added", "Workstations added to Polaris", stats.Added)
+	gauge("polarissync_errors", "Errors during the run", errors)
+	if stats.Licenses > 0 {
+		gauge("polarissync_workstations", "Workstations in Polaris after the run", stats.Workstations)
+		gauge("polarissync_workstation_licenses", "Workstations the Polaris license allows", stats.Licenses)
+	}
+	gauge("polarissync_run_duration_seconds", "Duration of the run", stats.End.Sub(stats.Start).Seconds())
+	gauge("polarissync_last_run_timestamp_seconds", "Time the run finished", stats.End.Unix())
+	gauge("polarissync_last_run_success", "1 if the run completed", success)
+}
+
+// Send the metrics to a prometheus pushgateway, grouped by tenant when syncing more than one library system
+func pushMetrics() error {
+	var body bytes.Buffer
+	//The pushgateway adds the tenant from the grouping key
+	writePrometheusMetrics(&body, "")
+
+	target := strings.TrimRight(config.Metrics.Pushgateway, "/") + "/metrics/job/polarissync"
+	if tenantName != "" {
+		target += "/tenant/" + url.PathEscape(tenantName)
+	}
+	req, err := http.NewRequest("PUT", target, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Write the metrics for the node_exporter textfile collector. The file is written under a temporary name and renamed
+// so the collector never reads a partial file
+func writeMetricsTextfile() error {
+	name := "polarissync"
+	labels := ""
+	if tenantName != "" {
+		name += "-" + tenantName
+		labels = fmt.Sprintf("tenant=%q", tenantName)
+	}
+	path := filepath.Join(config.Metrics.TextfileDirectory, name+".prom")
+
+	var body bytes.Buffer
+	writePrometheusMetrics(&body, labels)
+	if err := os.WriteFile(path+".tmp", body.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Decide whether a notifier should send for this run. The policy is always, changes (computers were removed, added
+// or newly found waiting to be removed, or something went wrong) or errors. A license alert is sent whatever the
+// policy
+func shouldNotify(policy string) bool {
+	problems := stats.alerting() || len(stats.Errors) > 0 || stats.LicenseAlert != ""
+	switch strings.ToLower(policy) {
+	case "changes":
+		return problems || stats.Removed > 0 || stats.Added > 0 || len(stats.NewPending) > 0
+	case "errors":
+		return problems
+	}
+	return true
+}
+
+// Post a JSON payload to a webhook, treating any status other than 2xx as an error
+func postJSON(url string, payload interface{}, headers map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// One line headline of the run, e.g. for a mail subject
+func summaryTitle() string {
+	title := "polarissync"
+	if reportOnly {
+		title += " report"
+	}
+	if tenantName != "" {
+		title += " (" + tenantName + ")"
+	}
+	switch {
+	case stats.failed():
+		return title + " failed"
+	case stats.Tripped != "":
+		return title + " stopped by a safety limit"
+	case len(stats.Errors) > 0:
+		return fmt.Sprintf("%s completed with %d errors", title, len(stats.Errors))
+	case stats.LicenseAlert != "":
+		return fmt.Sprintf("%s completed, %.0f%% of workstation licenses in use", title, stats.licenseUsage())
+	}
+	return fmt.Sprintf("%s completed, %d removed, %d added", title, stats.Removed, stats.Added)
+}
+
+// Plain text summary of the run, listing the computers that were changed or skipped and any errors
+func summaryText() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Run %s on %s\n", runID, versionString())
+	if tenantName != "" {
+		fmt.Fprintf(&b, "Tenant: %s\n", tenantName)
+	}
+	fmt.Fprintf(&b, "Started: %s\nDuration: %s\n\n", stats.Start.Format(time.RFC1123), stats.End.Sub(stats.Start).Round(time.Second))
+
+	if stats.failed() {
+		fmt.Fprintf(&b, "The run was stopped by an error: %s\n\n", stats.Fatal)
+	}
+	if stats.Tripped != "" {
+		fmt.Fprintf(&b, "No computers were removed: %s\n\n", stats.Tripped)
+	}
+	if stats.LicenseAlert != "" {
+		fmt.Fprintf(&b, "License alert: %s\n\n", stats.LicenseAlert)
+	}
+	if len(stats.FailedSources) > 0 {
+		fmt.Fprintf(&b, "Sources that failed and were left out: %s\n\n", strings.Join(stats.FailedSources, ", "))
+	}
+
+	sources := []string{}
+	for source := range stats.Sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		fmt.Fprintf(&b, "Computers from %s: %d\n", source, stats.Sources[source])
+	}
+	fmt.Fprintf(&b, "Orphans found: %d\nExempt: %d\nRemoved: %d\nAdded: %d\nRenamed: %d\nErrors: %d\n",
+		stats.Orphans, stats.Exempt, stats.Removed, stats.Added, stats.Renamed, len(stats.Errors))
+	if _, ok := stats.Sources["polaris"]; ok {
+		fmt.Fprintf(&b, "Workstations in Polaris: %s\n", stats.workstationsText())
+	}
+
+	list := func(heading string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s:\n", heading)
+		for _, item := range items {
+			fmt.Fprintf(&b, "  %s\n", item)
+		}
+	}
+	//The orphans are listed by branch or OU when report.groupBy is set
+	grouped := func(heading string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s:\n", heading)
+		for _, group := range groupComputers(names) {
+			indent := "  "
+			if group.Name != "" {
+				fmt.Fprintf(&b, "  %s:\n", group.Name)
+				indent = "    "
+			}
+			for _, name := range group.Computers {
+				fmt.Fprintf(&b, "%s%s\n", indent, name)
+			}
+		}
+	}
+	if reportOnly {
+		grouped("Orphans, not removed as this is a report", stats.OrphanComputers)
+	}
+	grouped("Removed", stats.RemovedComputers)
+	grouped("New removals, waiting "+config.Removals.Delay.String()+" so they can be vetoed", stats.NewPending)
+	grouped("Waiting to be removed", stats.Pending)
+	grouped("Kept for review by the removal checks", stats.Deferred)
+	list("Reappeared after an earlier run removed them", stats.Reappeared)
+	list("Skipped as exempt", stats.ExemptComputers)
+	list("Exempt but no longer in the database or any source", stats.StaleExemptions)
+	list("Added", stats.AddedComputers)
+	list("Errors", stats.Errors)
+	return b.String()
+}
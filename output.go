@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	//File the results of the run are written to, the format is taken from the extension
+	outputFile string
+	//Format of the results printed to stdout at the end of the run, text prints a short summary after the console log
+	outputFormat string
+)
+
+// Everything known about the run, for scripts
+type runResult struct {
+	RunId         string         `json:"runId"`
+	Tenant        string         `json:"tenant,omitempty"`
+	Version       string         `json:"version"`
+	Start         time.Time      `json:"start"`
+	End           time.Time      `json:"end"`
+	ReportOnly    bool           `json:"reportOnly"`
+	Simulated     bool           `json:"simulated,omitempty"`
+	Success       bool           `json:"success"`
+	Fatal         string         `json:"fatal,omitempty"`
+	Tripped       string         `json:"tripped,omitempty"`
+	FailedSources []string       `json:"failedSources,omitempty"`
+	Pending       []string       `json:"pending,omitempty"`
+	Deferred      []string       `json:"deferred,omitempty"`
+	Reappeared    []string       `json:"reappeared,omitempty"`
+	StaleExempt   []string       `json:"staleExemptions,omitempty"`
+	Sources       map[string]int `json:"sources"`
+	Orphans       int            `json:"orphans"`
+	Exempt        int            `json:"exempt"`
+	Removed       int            `json:"removed"`
+	RemoveFailed  int            `json:"removeFailed"`
+	Added         int            `json:"added"`
+	AddFailed     int            `json:"addFailed"`
+	Renamed       int            `json:"renamed"`
+	RenameFailed  int            `json:"renameFailed"`
+	Workstations  int            `json:"workstations"`
+	Licenses      int            `json:"licenses,omitempty"`
+	LicenseAlert  string         `json:"licenseAlert,omitempty"`
+	Actions       []actionResult `json:"actions"`
+	Errors        []string       `json:"errors"`
+}
+
+type actionResult struct {
+	Computer string    `json:"computer"`
+	Decision string    `json:"decision"`
+	Reason   string    `json:"reason"`
+	Sources  []string  `json:"sources"`
+	Time     time.Time `json:"time"`
+}
+
+func currentRunResult() runResult {
+	result := runResult{RunId: runID, Tenant: tenantName, Version: version, Start: stats.Start, End: stats.End,
+		ReportOnly: reportOnly, Simulated: simulate, Success: !stats.failed(), Fatal: stats.Fatal, Tripped: stats.Tripped,
+		FailedSources: stats.FailedSources, Pending: stats.Pending, Deferred: stats.Deferred, Reappeared: stats.Reappeared, StaleExempt: stats.StaleExemptions,
+		Sources: stats.Sources,
+		Orphans: stats.Orphans, Exempt: stats.Exempt, Removed: stats.Removed, RemoveFailed: stats.RemoveFailed,
+		Added: stats.Added, AddFailed: stats.AddFailed, Renamed: stats.Renamed, RenameFailed: stats.RenameFailed,
+		Workstations: stats.Workstations, Licenses: stats.Licenses, LicenseAlert: stats.LicenseAlert, Actions: []actionResult{}, Errors: []string{}}
+	for _, d := range stats.Decisions {
+		result.Actions = append(result.Actions, actionResult(d))
+	}
+	result.Errors = append(result.Errors, stats.Errors...)
+	return result
+}
+
+// Print the results as a single json document
+func writeJSONResult(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(currentRunResult())
+}
+
+func writeOutputFile(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return writeCSVFile(path)
+	case ".html", ".htm":
+		return writeHTMLFile(path)
+	case ".xlsx":
+		return writeXLSXFile(path)
+	case ".json":
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err = writeJSONResult(f); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	return fmt.Errorf("unsupported output format %s", filepath.Ext(path))
+}
+
+// Print a few lines on how the run went, so the outcome of a manual or scheduled run can be seen whatever the
+// logging settings
+func writeConsoleSummary(w io.Writer) {
+	outcome := "finished"
+	switch {
+	case stats.failed():
+		outcome = "stopped by an error"
+	case stats.Tripped != "":
+		outcome = "stopped by a safety limit"
+	}
+	mode := ""
+	if simulate {
+		mode = " (simulated)"
+	} else if reportOnly {
+		mode = " (report only)"
+	}
+	fmt.Fprintf(w, "\nRun %s %s after %s%s\n", runID, outcome, stats.End.Sub(stats.Start).Round(time.Second), mode)
+
+	sources := []string{}
+	for source := range stats.Sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	counts := []string{}
+	for _, source := range sources {
+		counts = append(counts, fmt.Sprintf("%s %d", source, stats.Sources[source]))
+	}
+	if len(counts) > 0 {
+		fmt.Fprintf(w, "  Computers: %s\n", strings.Join(counts, ", "))
+	}
+	fmt.Fprintf(w, "  Orphans: %d, removed: %d, exempt: %d, added: %d, renamed: %d, failed: %d\n",
+		stats.Orphans, stats.Removed, stats.Exempt, stats.Added, stats.Renamed, stats.RemoveFailed+stats.AddFailed+stats.RenameFailed)
+	if _, ok := stats.Sources["polaris"]; ok {
+		fmt.Fprintf(w, "  Workstations: %s\n", stats.workstationsText())
+	}
+	if stats.LicenseAlert != "" {
+		fmt.Fprintf(w, "  License alert: %s\n", stats.LicenseAlert)
+	}
+	if len(stats.FailedSources) > 0 {
+		fmt.Fprintf(w, "  Failed sources: %s\n", strings.Join(stats.FailedSources, ", "))
+	}
+	if len(stats.Pending) > 0 {
+		fmt.Fprintf(w, "  Waiting to be removed: %d, %d found this run\n", len(stats.Pending), len(stats.NewPending))
+	}
+	if len(stats.Deferred) > 0 {
+		fmt.Fprintf(w, "  Kept for review by the removal checks: %d\n", len(stats.Deferred))
+	}
+	if len(stats.StaleExemptions) > 0 {
+		fmt.Fprintf(w, "  Exemptions for computers that no longer exist: %s\n", strings.Join(stats.StaleExemptions, ", "))
+	}
+	if len(stats.Reappeared) > 0 {
+		fmt.Fprintf(w, "  Reappeared after being removed: %s\n", strings.Join(stats.Reappeared, ", "))
+	}
+	if stats.Tripped != "" {
+		fmt.Fprintf(w, "  Not removed: %s\n", stats.Tripped)
+	}
+	if stats.Fatal != "" {
+		fmt.Fprintf(w, "  Error: %s\n", stats.Fatal)
+	}
+	if len(stats.Errors) > 0 {
+		fmt.Fprintf(w, "  Errors: %d, see the log for details\n", len(stats.Errors))
+	}
+}
+
+// Write a row per computer with the decision taken, for reviewing a run in a spreadsheet
+func writeCSVFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"computer", "decision", "reason", "sources", "timestamp"})
+	for _, d := range stats.Decisions {
+		w.Write([]string{d.Computer, d.Decision, d.Reason, strings.Join(d.Sources, ";"), d.Time.Format(time.RFC3339)})
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"time"
+)
+
+// An orphan waiting out removals.delay before it is removed
+type pendingRemoval struct {
+	Computer string    `json:"computer"`
+	Since    time.Time `json:"since"`
+	Due      time.Time `json:"due"`
+	Approved bool      `json:"approved,omitempty"`
+	Vetoed   bool      `json:"vetoed,omitempty"`
+	//Who approved or vetoed the removal
+	By string `json:"by,omitempty"`
+}
+
+// The pending removals file for a tenant
+func pendingPath(tenant string) string {
+	return configRelativePath(logFileName(config.Removals.PendingFile, tenant, clock.Now()))
+}
+
+// The removals waiting out the delay, by normalized name
+func loadPending(tenant string) (map[string]pendingRemoval, error) {
+	pending := map[string]pendingRemoval{}
+	data, err := os.ReadFile(pendingPath(tenant))
+	if errors.Is(err, os.ErrNotExist) {
+		return pending, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the pending removals: %w", err)
+	}
+	if err = json.Unmarshal(data, &pending); err != nil {
+		return nil, fmt.Errorf("%s is not valid: %w", pendingPath(tenant), err)
+	}
+	return pending, nil
+}
+
+// Replace the pending removals file, through a temporary file so a reader never sees it half written
+func savePending(tenant string, pending map[string]pendingRemoval) error {
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := pendingPath(tenant)
+	if err = os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Take the lock on the pending removals of a tenant, held while they are read, changed and saved so a veto given
+// during a run isn't overwritten when the run saves. Waits for another writer to finish. Returns the release
+func lockPending(tenant string) (func(), error) {
+	f, err := os.OpenFile(pendingPath(tenant)+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to lock the pending removals: %w", err)
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for lockFile(f) != nil {
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("the pending removals are locked by another process")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return func() { f.Close() }, nil
+}
+
+// Hold back orphans until removals.delay has passed since they were first found, so new candidates can be announced
+// and vetoed before anything is deleted. Returns the orphans that are due, or approved early. Computers that are
+// no longer orphans drop out of the file
+func stagePendingRemovals(orphans []string) ([]string, error) {
+	release, err := lockPending(tenantName)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	pending, err := loadPending(tenantName)
+	if err != nil {
+		return nil, err
+	}
+	now := clock.Now()
+	current := map[string]pendingRemoval{}
+	due := []string{}
+	for _, name := range orphans {
+		key := normalize(name)
+		p, ok := pending[key]
+		switch {
+		case !ok:
+			p = pendingRemoval{Computer: name, Since: now, Due: now.Add(config.Removals.Delay)}
+			stats.NewPending = append(stats.NewPending, name)
+			fallthrough
+		case !p.Approved && !p.Vetoed && now.Before(p.Due):
+			stats.Pending = append(stats.Pending, name)
+			recordDecision(name, "pending", "removal due after "+p.Due.Format("2006-01-02 15:04"))
+			writeInfoFields(name+" will be removed after "+p.Due.Format("2006-01-02 15:04")+" unless vetoed", logFields{"computer": name, "action": "pending"})
+			current[key] = p
+		case p.Vetoed:
+			recordDecision(name, "vetoed", "removal vetoed by "+p.By)
+			writeInfoFields("Not removing "+name+", vetoed by "+p.By, logFields{"computer": name, "action": "vetoed"})
+			current[key] = p
+		default:
+			due = append(due, name)
+			dueRemovals[key] = p
+			current[key] = p
+		}
+	}
+	//Computers that are due stay in the file, so one that fails to be removed is tried again next run without
+	//waiting again. Once removed they are no longer orphans and drop out
+	if !simulate {
+		if err = savePending(tenantName, current); err != nil {
+			return nil, fmt.Errorf("unable to save the pending removals: %w", err)
+		}
+	}
+	return due, nil
+}
+
+// Approve or veto a pending removal of a tenant, recording who did it
+func decidePending(tenant string, name string, approve bool, by string) error {
+	release, err := lockPending(tenant)
+	if err != nil {
+		return err
+	}
+	defer release()
+	pending, err := loadPending(tenant)
+	if err != nil {
+		return err
+	}
+	key := normalize(name)
+	p, ok := pending[key]
+	if !ok {
+		return fmt.Errorf("%s has no pending removal", name)
+	}
+	p.Approved, p.Vetoed, p.By = approve, !approve, by
+	pending[key] = p
+	return savePending(tenant, pending)
+}
+
+// List, approve or veto the removals waiting out removals.delay
+func runApprovalCommand(args []string) int {
+	fs := flag.NewFlagSet("approval", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: polarissync approval [flags] list | approve <computer>... | veto <computer>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+
+	switch action := fs.Arg(0); action {
+	case "list":
+		pending, err := loadPending(tenantName)
+		if err != nil {
+			return exitWithError(err)
+		}
+		names := []string{}
+		for name := range pending {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p := pending[name]
+			state := "due " + p.Due.Local().Format("2006-01-02 15:04")
+			if p.Approved {
+				state = "approved by " + p.By
+			} else if p.Vetoed {
+				state = "vetoed by " + p.By
+			}
+			fmt.Printf("%-20s %s\n", p.Computer, state)
+		}
+	case "approve", "veto":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return exitUsage
+		}
+		by := "unknown"
+		if u, err := user.Current(); err == nil {
+			by = u.Username
+		}
+		for _, name := range fs.Args()[1:] {
+			if err := decidePending(tenantName, name, action == "approve", by); err != nil {
+				return exitWithError(err)
+			}
+			fmt.Printf("%s %s\n", normalize(name), approvalActions[action][1])
+		}
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+	return 0
+}
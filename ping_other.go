@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Whether the host answers a single ping within the timeout
+func ping(host string, timeout time.Duration) bool {
+	seconds := int(timeout.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	return exec.Command("ping", "-c", "1", "-W", strconv.Itoa(seconds), host).Run() == nil
+}
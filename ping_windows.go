@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Whether the host answers a single ping within the timeout. ping exits with 0 on a reply, but also on a
+// "destination unreachable" from a router, so the output is checked for a reply from the host
+func ping(host string, timeout time.Duration) bool {
+	out, err := exec.Command("ping", "-n", "1", "-w", strconv.FormatInt(timeout.Milliseconds(), 10), host).Output()
+	return err == nil && strings.Contains(string(out), "TTL=")
+}
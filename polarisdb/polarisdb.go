@@ -0,0 +1,543 @@
+// Package polarisdb reads and changes the workstations in a Polaris ILS database
+package polarisdb
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/venutios/polarissync/config"
+)
+
+// A Polaris branch or library, whose abbreviation prefixes the names of its computers
+type Organization struct {
+	OrganizationID int
+	Abbreviation   string
+}
+
+// A workstation with the organization it belongs to
+type Workstation struct {
+	WorkstationID int64
+	ComputerName  string
+	DisplayName   string
+	//The abbreviation and name of the organization
+	Abbreviation string
+	Branch       string
+	Enabled      bool
+	Created      time.Time
+}
+
+// Build the connection string for a trusted connection, or one specifying credentials
+func ConnString(db config.DatabaseConnection) string {
+	if db.Trusted {
+		return fmt.Sprintf("server=%s;port=%d;database=%s;trusted_connection=yes", db.Host, db.Port, db.Name)
+	}
+	//Without a domain the account is a SQL login, such as the dynamic credentials issued by vault
+	username := db.Username
+	if db.Domain != "" {
+		username = db.Domain + "\\" + db.Username
+	}
+	return fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d;database=%s", db.Host, username, db.Password, db.Port, db.Name)
+}
+
+// The Polaris database reached directly over SQL. Safe for use by several goroutines at once
+type SQL struct {
+	DB *sql.DB
+	//The column of Polaris.Workstations compared with the directory, ComputerName when empty
+	Column string
+}
+
+// A plain column name, which is all that can be safely put into a query
+var columnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// The key column quoted for a query
+func (s *SQL) column() (string, error) {
+	if s.Column == "" {
+		return "ComputerName", nil
+	}
+	if !columnName.MatchString(s.Column) {
+		return "", fmt.Errorf("invalid workstation column %q", s.Column)
+	}
+	return "[" + s.Column + "]", nil
+}
+
+// Open a database using a connection string from ConnString
+func OpenSQL(connString string) (*SQL, error) {
+	db, err := sql.Open("mssql", connString)
+	if err != nil {
+		return nil, fmt.Errorf("database connection failed: %w", err)
+	}
+	return &SQL{DB: db}, nil
+}
+
+func (s *SQL) Close() error {
+	return s.DB.Close()
+}
+
+// The key column of every workstation, as stored
+func (s *SQL) Workstations() ([]string, error) {
+	column, err := s.column()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.Query("select " + column + " from Polaris.Workstations where " + column + " is not null")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load workstations: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var ComputerName string
+		if err := rows.Scan(&ComputerName); err != nil {
+			return nil, fmt.Errorf("error reading record from database: %w", err)
+		}
+		names = append(names, ComputerName)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading from database: %w", err)
+	}
+	return names, nil
+}
+
+// Every organization, with the abbreviation in upper case
+func (s *SQL) Organizations() ([]Organization, error) {
+	rows, err := s.DB.Query("select OrganizationID, Abbreviation from Polaris.Organizations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load organizations: %w", err)
+	}
+	defer rows.Close()
+
+	var orgs []Organization
+	for rows.Next() {
+		var Org Organization
+		if err := rows.Scan(&Org.OrganizationID, &Org.Abbreviation); err != nil {
+			return nil, fmt.Errorf("error reading record from database: %w", err)
+		}
+		Org.Abbreviation = strings.ToUpper(Org.Abbreviation)
+		orgs = append(orgs, Org)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading from database: %w", err)
+	}
+	return orgs, nil
+}
+
+// The organization a computer belongs to, from the abbreviation its name starts with. Computers without a matching
+// prefix belong to the system organization, 1
+func OrganizationFor(orgs []Organization, name string) int {
+	orgID := 1
+	for i := range orgs {
+		if len(name) >= 2 && orgs[i].Abbreviation == name[0:2] {
+			orgID = orgs[i].OrganizationID
+		}
+	}
+	return orgID
+}
+
+// Delete the workstation with the given name in the key column
+func (s *SQL) DeleteWorkstation(name string) error {
+	column, err := s.column()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec("delete from Polaris.Workstations where "+column+" = ?", name)
+	return err
+}
+
+// Change the name in the key column of a workstation, keeping its id, branch and statistics. A display name that
+// was the old computer name follows it
+func (s *SQL) RenameWorkstation(oldName string, newName string) error {
+	column, err := s.column()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec("update Polaris.Workstations set DisplayName = case when DisplayName = ? then ? else DisplayName end, "+column+" = ? where "+column+" = ?", oldName, newName, newName, oldName)
+	return err
+}
+
+// Create an enabled workstation for the computer, returning its id. A key column other than the computer and
+// display names is filled in too, so the next run matches the workstation
+func (s *SQL) AddWorkstation(orgID int, name string) (int64, error) {
+	column, err := s.column()
+	if err != nil {
+		return 0, err
+	}
+	var workstationID int64
+	err = s.DB.QueryRow("insert into Polaris.Workstations(OrganizationID,DisplayName,ComputerName,CreatorID,CreationDate,Enabled,Status,LeapAllowed,TerminalServer) output inserted.WorkstationID values (?,?,?,?,GETDATE(),?,?,?,?)", orgID, name, name, 1, 1, 0, 1, 0).Scan(&workstationID)
+	if err != nil || s.Column == "" || strings.EqualFold(s.Column, "ComputerName") || strings.EqualFold(s.Column, "DisplayName") {
+		return workstationID, err
+	}
+	_, err = s.DB.Exec("update Polaris.Workstations set "+column+" = ? where WorkstationID = ?", name, workstationID)
+	return workstationID, err
+}
+
+// Add a workstation to a workstation group. Polaris 7.5 and later need new workstations in a group
+func (s *SQL) AddToGroup(groupID int, workstationID int64) error {
+	_, err := s.DB.Exec("insert into Polaris.GroupWorkstations(GroupID, WorkstationID) values (?,?)", groupID, workstationID)
+	return err
+}
+
+// The workstations with the given name in the key column, for looking into one computer without loading them all
+func (s *SQL) Lookup(name string) ([]Workstation, error) {
+	column, err := s.column()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.Query("select w.WorkstationID, w.ComputerName, w.DisplayName, o.Abbreviation, o.Name, w.Enabled, w.CreationDate from Polaris.Workstations w join Polaris.Organizations o on o.OrganizationID = w.OrganizationID where w."+column+" = ?", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up workstation: %w", err)
+	}
+	defer rows.Close()
+
+	var workstations []Workstation
+	for rows.Next() {
+		var w Workstation
+		var displayName, branch sql.NullString
+		var created sql.NullTime
+		if err := rows.Scan(&w.WorkstationID, &w.ComputerName, &displayName, &w.Abbreviation, &branch, &w.Enabled, &created); err != nil {
+			return nil, fmt.Errorf("error reading record from database: %w", err)
+		}
+		w.DisplayName, w.Branch, w.Created = displayName.String, branch.String, created.Time
+		workstations = append(workstations, w)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading from database: %w", err)
+	}
+	return workstations, nil
+}
+
+// The key column of the workstations with a staff client signed in, which Polaris marks in their Status. query
+// replaces the default for sites that track sessions differently, it must return the key column
+func (s *SQL) SignedIn(query string) ([]string, error) {
+	column, err := s.column()
+	if err != nil {
+		return nil, err
+	}
+	if query == "" {
+		query = "select " + column + " from Polaris.Workstations where Status <> 0 and " + column + " is not null"
+	}
+	return s.names(query)
+}
+
+// The key column of the workstations with circulation or other transactions since the given time, from the
+// transaction headers in the PolarisTransactions database
+func (s *SQL) ActiveSince(since time.Time) ([]string, error) {
+	column, err := s.column()
+	if err != nil {
+		return nil, err
+	}
+	return s.names("select w."+column+" from Polaris.Workstations w where w."+column+" is not null and exists "+
+		"(select 1 from PolarisTransactions.Polaris.TransactionHeaders t where t.WorkstationID = w.WorkstationID and t.TranClientDate >= ?)", since)
+}
+
+// Run a query returning one name per row
+func (s *SQL) names(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error reading record from database: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading from database: %w", err)
+	}
+	return names, nil
+}
+
+// Every column of a workstation and the rows of other tables referring to it, as read before it was deleted
+type WorkstationBackup struct {
+	Name     string      `json:"name"`
+	Rows     []Row       `json:"rows,omitempty"`
+	Children []ChildRows `json:"children,omitempty"`
+}
+
+// The rows of a table with a foreign key to Polaris.Workstations
+type ChildRows struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	Rows   []Row  `json:"rows"`
+}
+
+// The columns of a row by name
+type Row map[string]Value
+
+// A column value with the SQL type of its column, so it can be written back as it was. Binary values are base64
+// with the encoding set, so they are restored byte for byte
+type Value struct {
+	Type     string      `json:"type"`
+	Encoding string      `json:"encoding,omitempty"`
+	Value    interface{} `json:"value"`
+}
+
+// The tables with a foreign key to Polaris.Workstations, quoted, with the quoted column of the key
+func (s *SQL) childTables() (map[string]string, error) {
+	rows, err := s.DB.Query(`select schema_name(t.schema_id), t.name, c.name from sys.foreign_key_columns fkc
+		join sys.tables t on t.object_id = fkc.parent_object_id
+		join sys.columns c on c.object_id = fkc.parent_object_id and c.column_id = fkc.parent_column_id
+		where fkc.referenced_object_id = object_id('Polaris.Workstations')`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the tables referring to workstations: %w", err)
+	}
+	defer rows.Close()
+	children := map[string]string{}
+	for rows.Next() {
+		var schema, table, col string
+		if err = rows.Scan(&schema, &table, &col); err != nil {
+			return nil, fmt.Errorf("error reading record from database: %w", err)
+		}
+		children[quoteName(schema)+"."+quoteName(table)] = quoteName(col)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading from database: %w", err)
+	}
+	return children, nil
+}
+
+// Read the whole of each named workstation, with the rows of every table whose foreign keys refer to it. The child
+// tables are found from the foreign keys, so a table added by an upgrade is included too
+func (s *SQL) Backup(names []string) ([]WorkstationBackup, error) {
+	column, err := s.column()
+	if err != nil {
+		return nil, err
+	}
+	children, err := s.childTables()
+	if err != nil {
+		return nil, err
+	}
+	tables := []string{}
+	for table := range children {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	backups := []WorkstationBackup{}
+	for _, name := range names {
+		b := WorkstationBackup{Name: name}
+		if b.Rows, err = s.rows("select * from Polaris.Workstations where "+column+" = ?", name); err != nil {
+			return nil, err
+		}
+		for _, row := range b.Rows {
+			id, err := row["WorkstationID"].arg()
+			if err != nil {
+				return nil, err
+			}
+			for _, table := range tables {
+				childRows, err := s.rows("select * from "+table+" where "+children[table]+" = ?", id)
+				if err != nil {
+					return nil, err
+				}
+				if len(childRows) > 0 {
+					b.Children = append(b.Children, ChildRows{Table: table, Column: children[table], Rows: childRows})
+				}
+			}
+		}
+		backups = append(backups, b)
+	}
+	return backups, nil
+}
+
+// Put a workstation back from its backup, with the rows that referred to it, keeping its id. Nothing is written
+// unless every row can be, and a workstation already back in Polaris is left alone
+func (s *SQL) Restore(b WorkstationBackup) error {
+	column, err := s.column()
+	if err != nil {
+		return err
+	}
+	if len(b.Rows) == 0 {
+		return fmt.Errorf("the backup of %s holds no rows", b.Name)
+	}
+	existing, err := s.names("select "+column+" from Polaris.Workstations where "+column+" = ?", b.Name)
+	if err != nil {
+		return fmt.Errorf("failed to look up workstation: %w", err)
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("%s is already in Polaris", b.Name)
+	}
+	//Only tables that still refer to workstations are written, the names in the file are never trusted
+	children, err := s.childTables()
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, row := range b.Rows {
+		if err = insertRow(tx, "[Polaris].[Workstations]", row); err != nil {
+			return err
+		}
+	}
+	for _, c := range b.Children {
+		if _, ok := children[c.Table]; !ok {
+			return fmt.Errorf("%s no longer refers to workstations", c.Table)
+		}
+		for _, row := range c.Rows {
+			if err = insertRow(tx, c.Table, row); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Insert a row from a backup into a quoted table, leaving out the columns SQL Server fills in itself. An identity
+// column gets its old value back
+func insertRow(tx *sql.Tx, table string, row Row) error {
+	rows, err := tx.Query("select name from sys.columns where object_id = object_id(?) and is_computed = 0 and system_type_id != 189", table)
+	if err != nil {
+		return fmt.Errorf("failed to read the columns of %s: %w", table, err)
+	}
+	writable := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading record from database: %w", err)
+		}
+		writable[name] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("error reading from database: %w", err)
+	}
+
+	names := []string{}
+	for name := range row {
+		if writable[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	columns, params, args := []string{}, []string{}, []interface{}{}
+	for _, name := range names {
+		arg, err := row[name].arg()
+		if err != nil {
+			return fmt.Errorf("column %s of %s: %w", name, table, err)
+		}
+		columns = append(columns, quoteName(name))
+		params = append(params, "?")
+		args = append(args, arg)
+	}
+
+	var identity int
+	if err = tx.QueryRow("select objectproperty(object_id(?), 'TableHasIdentity')", table).Scan(&identity); err != nil {
+		return fmt.Errorf("failed to read the columns of %s: %w", table, err)
+	}
+	insert := "insert into " + table + " (" + strings.Join(columns, ", ") + ") values (" + strings.Join(params, ", ") + ")"
+	if identity == 1 {
+		insert = "set identity_insert " + table + " on; " + insert + "; set identity_insert " + table + " off"
+	}
+	if _, err = tx.Exec(insert, args...); err != nil {
+		return fmt.Errorf("failed to restore a row of %s: %w", table, err)
+	}
+	return nil
+}
+
+// Quote a name from the catalog for use in a query
+func quoteName(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// Keep a value read from a column of the given SQL type. Decimals and money come back as their text in bytes and
+// are kept as text, other bytes are binary
+func newValue(sqlType string, v interface{}) Value {
+	value := Value{Type: sqlType, Value: v}
+	if b, ok := v.([]byte); ok {
+		switch sqlType {
+		case "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
+			value.Value = string(b)
+		default:
+			value.Encoding, value.Value = "base64", base64.StdEncoding.EncodeToString(b)
+		}
+	}
+	return value
+}
+
+// The value to pass to a query, converted back from json to the type of its column. Numbers are expected to be
+// decoded as json.Number, so a bigint keeps every digit
+func (v Value) arg() (interface{}, error) {
+	if v.Value == nil {
+		return nil, nil
+	}
+	if v.Encoding == "base64" {
+		s, ok := v.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("a base64 value must be a string")
+		}
+		return base64.StdEncoding.DecodeString(s)
+	}
+	switch v.Type {
+	case "BIGINT", "INT", "SMALLINT", "TINYINT":
+		switch n := v.Value.(type) {
+		case json.Number:
+			return n.Int64()
+		case float64:
+			return int64(n), nil
+		}
+		return v.Value, nil
+	case "REAL", "FLOAT":
+		if n, ok := v.Value.(json.Number); ok {
+			return n.Float64()
+		}
+		return v.Value, nil
+	case "DATE", "DATETIME", "DATETIME2", "SMALLDATETIME", "DATETIMEOFFSET", "TIME":
+		if s, ok := v.Value.(string); ok {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+	}
+	if n, ok := v.Value.(json.Number); ok {
+		return n.String(), nil
+	}
+	return v.Value, nil
+}
+
+// Every column of the rows a query returns, by column name, with the type of each column
+func (s *SQL) rows(query string, args ...interface{}) ([]Row, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	defer rows.Close()
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Row{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("error reading record from database: %w", err)
+		}
+		row := Row{}
+		for i, c := range columns {
+			row[c.Name()] = newValue(c.DatabaseTypeName(), values[i])
+		}
+		result = append(result, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading from database: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reports how far a long step has got every logging.progressInterval, so someone watching a long run can tell it
+// hasn't hung
+type progress struct {
+	step  string
+	total int
+	done  int
+	last  time.Time
+}
+
+// Start tracking a step. A total of 0 means the size isn't known in advance, e.g. records streaming from a source
+func newProgress(step string, total int) *progress {
+	return &progress{step: step, total: total, last: time.Now()}
+}
+
+func (p *progress) add(n int) {
+	p.done += n
+	//A run still making progress is no reason for systemd to restart the daemon
+	sdWatchdog()
+	if config.Logging.ProgressInterval <= 0 || time.Since(p.last) < config.Logging.ProgressInterval {
+		return
+	}
+	p.last = time.Now()
+
+	msg := fmt.Sprintf("%s: %d so far", p.step, p.done)
+	if p.total > 0 {
+		msg = fmt.Sprintf("%s: %d of %d (%d%%)", p.step, p.done, p.total, p.done*100/p.total)
+	}
+	writeInfoFields(msg, logFields{"step": p.step, "done": p.done, "total": p.total})
+	sdNotify("STATUS=" + msg)
+}
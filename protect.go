@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Encrypt every plain text password in the config file with DPAPI, replacing it with a dpapi:// reference that is
+// decrypted when the config is loaded
+func runProtect(path string) int {
+	return rewriteSecrets(path, "Protected", func(value string) (string, bool, error) {
+		if !isPlainSecret(value) {
+			return "", false, nil
+		}
+		encrypted, err := dpapiProtect([]byte(value))
+		if err != nil {
+			return "", false, err
+		}
+		return "dpapi://" + base64.StdEncoding.EncodeToString(encrypted), true, nil
+	})
+}
+
+// Apply a change to each credential in the config file, including those in profiles and tenant blocks, and save it.
+// The transform reports whether it changed the value. Only the values are replaced, the rest of the file is kept as
+// written, comments and all
+func rewriteSecrets(path string, action string, transform func(value string) (string, bool, error)) int {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		fmt.Println("Unable to read the config file: " + err.Error())
+		return exitFatal
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("Unable to read the config file: " + err.Error())
+		return exitFatal
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Println("Unable to read the config file: " + err.Error())
+		return exitFatal
+	}
+
+	//Credentials by the name of their setting and their value, which is how they are found in the file
+	secrets := map[string]map[string]string{}
+	settings := map[string]string{}
+	flattenSettings(v.AllSettings(), "", settings)
+	for key, value := range settings {
+		if !isSecretKey(key) || value == "" {
+			continue
+		}
+		name := key[strings.LastIndex(key, ".")+1:]
+		if secrets[name] == nil {
+			secrets[name] = map[string]string{}
+		}
+		secrets[name][value] = key
+	}
+
+	//Find each credential where it is set, whether on a line of its own or inline in a json or flow style block
+	text := string(data)
+	type edit struct {
+		start, end int
+		value      string
+		key        string
+	}
+	edits := []edit{}
+	found := map[string]bool{}
+	end := 0
+	for _, loc := range settingName.FindAllStringSubmatchIndex(text, -1) {
+		if loc[0] < end {
+			continue
+		}
+		start := loc[1]
+		line := text[start:]
+		if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+			line = line[:i]
+		}
+		names := secrets[strings.ToLower(text[loc[2]:loc[3]])]
+		for _, flow := range []bool{false, true} {
+			value, quote, length, ok := parseSettingValue(line, flow)
+			key, secret := names[value]
+			if !ok || !secret {
+				continue
+			}
+			found[key] = true
+			changed, isChanged, err := transform(value)
+			if err != nil {
+				fmt.Printf("Unable to update %s: %s\n", key, err.Error())
+				return exitFatal
+			}
+			if isChanged {
+				edits = append(edits, edit{start, start + length, quoteSettingValue(changed, quote), key})
+			}
+			end = start + length
+			break
+		}
+	}
+	for _, keys := range secrets {
+		for _, key := range keys {
+			if !found[key] {
+				//Such as a block scalar, changing the rest would leave it behind
+				fmt.Println("Unable to find the value of " + key + " in the config file, change it by hand")
+				return exitFatal
+			}
+		}
+	}
+
+	if len(edits) == 0 {
+		fmt.Println("No passwords needed updating")
+		return exitSuccess
+	}
+	for i := len(edits) - 1; i >= 0; i-- {
+		text = text[:edits[i].start] + edits[i].value + text[edits[i].end:]
+	}
+	if err = os.WriteFile(path+".tmp", []byte(text), info.Mode().Perm()); err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		fmt.Println("Unable to write the config file: " + err.Error())
+		return exitFatal
+	}
+	for _, e := range edits {
+		fmt.Println(action + " " + e.key)
+	}
+	return exitSuccess
+}
+
+// Flatten settings read by viper into dotted lower case keys, numbering the entries of lists such as tenants
+func flattenSettings(value interface{}, prefix string, settings map[string]string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			flattenSettings(item, prefix+strings.ToLower(k)+".", settings)
+		}
+	case map[interface{}]interface{}:
+		for k, item := range value {
+			flattenSettings(item, prefix+strings.ToLower(fmt.Sprint(k))+".", settings)
+		}
+	case []interface{}:
+		for i, item := range value {
+			flattenSettings(item, fmt.Sprintf("%s%d.", prefix, i), settings)
+		}
+	default:
+		settings[strings.TrimSuffix(prefix, ".")] = fmt.Sprint(value)
+	}
+}
+
+// The name of a setting in yaml, json or toml, up to the start of its value
+var settingName = regexp.MustCompile(`(?m)(?:^|[\s{,])["']?([A-Za-z0-9_]+)["']?[ \t]*[:=][ \t]*`)
+
+// Read the value at the start of a line, returning it, the quote it was written with and its length. A bare value
+// runs to the end of the line or a comment, or in a flow style block to the next comma or bracket
+func parseSettingValue(line string, flow bool) (value string, quote string, length int, ok bool) {
+	if line == "" {
+		return "", "", 0, false
+	}
+	switch line[0] {
+	case '"':
+		for i := 1; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == '"' {
+				value, err := strconv.Unquote(line[:i+1])
+				return value, `"`, i + 1, err == nil
+			}
+		}
+	case '\'':
+		for i := 1; i < len(line); i++ {
+			if line[i] == '\'' {
+				if i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					continue
+				}
+				return strings.ReplaceAll(line[1:i], "''", "'"), "'", i + 1, true
+			}
+		}
+	default:
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		if i := strings.IndexAny(line, ",}]"); flow && i >= 0 {
+			line = line[:i]
+		}
+		value = strings.TrimRight(line, " \t")
+		return value, "", len(value), value != "" && !strings.ContainsAny(value[:1], "|>[{")
+	}
+	return "", "", 0, false
+}
+
+// Write a value back with the quote it had, quoting a bare value that would no longer read back as written
+func quoteSettingValue(value string, quote string) string {
+	switch {
+	case quote == "'" && !strings.ContainsAny(value, "\n\r"):
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	case quote == "" && plainSetting.MatchString(value):
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// Values safe to leave unquoted, such as the base64 of ENC(...) and dpapi:// references
+var plainSetting = regexp.MustCompile(`^[A-Za-z0-9+/=():._-]+$`)
+
+// Empty values, references to secret stores and encrypted values are left alone
+func isPlainSecret(value string) bool {
+	return value != "" && !strings.Contains(value, "://") && !isEncryptedValue(value)
+}
+
+// Keys holding credentials, including those inside profiles
+func isSecretKey(key string) bool {
+	return strings.HasSuffix(key, "password") || strings.HasSuffix(key, "clientsecret") || strings.HasSuffix(key, "secretid") ||
+		strings.HasSuffix(key, "secretaccesskey") || strings.HasSuffix(key, "vault.token") ||
+		strings.HasSuffix(key, "webhookurl") || strings.HasSuffix(key, "slack.token") ||
+		strings.HasSuffix(key, "routingkey") || strings.HasSuffix(key, "apikey") ||
+		strings.HasSuffix(key, "sentry.dsn") || strings.HasSuffix(key, "approval.key")
+}
+
+// Decrypt a dpapi://base64 reference created by the protect command
+func resolveDPAPISecret(ref string) (string, error) {
+	encrypted, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ref, "dpapi://"))
+	if err != nil || len(encrypted) == 0 {
+		return "", fmt.Errorf("invalid dpapi reference")
+	}
+	plain, err := dpapiUnprotect(encrypted)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
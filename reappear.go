@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Computers removed by an earlier run that are back in the directory or Polaris, with when they were removed, by
+// normalized name
+var reappeared = map[string]string{}
+
+// Look through the history for computers this run found that an earlier run removed and that haven't been seen
+// since, which points to a machine that was only missing for a while rather than decommissioned
+func findReappeared() error {
+	if config.History.File == "" {
+		return nil
+	}
+	candidates := nameRules().NameSet(append(append([]string{}, dbComputers...), matcher.New()...))
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	db, err := openHistory()
+	if err != nil {
+		return fmt.Errorf("unable to open the history: %w", err)
+	}
+	defer db.Close()
+	//When each decision was last made by a run that could change the database
+	rows, err := db.Query(`select a.computer, a.decision, max(a.time) from run_actions a join runs r on r.run_id = a.run_id
+		where r.tenant = ? and r.report_only = 0 group by a.computer, a.decision`, tenantName)
+	if err != nil {
+		return fmt.Errorf("unable to read the history: %w", err)
+	}
+	defer rows.Close()
+	type seen struct {
+		decision string
+		at       string
+	}
+	latest := map[string]seen{}
+	removed := map[string]string{}
+	for rows.Next() {
+		var computer string
+		var s seen
+		if err := rows.Scan(&computer, &s.decision, &s.at); err != nil {
+			return fmt.Errorf("unable to read the history: %w", err)
+		}
+		name := normalize(computer)
+		if !candidates[name] {
+			continue
+		}
+		if s.decision == "remove" {
+			removed[name] = s.at
+		}
+		//Times are stored as RFC3339 in UTC, so they sort as text
+		if s.at > latest[name].at {
+			latest[name] = s
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("unable to read the history: %w", err)
+	}
+	//A computer left out by the warn action is still waiting for someone to look at it
+	for name, s := range latest {
+		if s.decision == "remove" || s.decision == "reappeared" {
+			reappeared[name] = removed[name]
+		}
+	}
+
+	for name := range reappeared {
+		stats.Reappeared = append(stats.Reappeared, name)
+	}
+	sort.Strings(stats.Reappeared)
+	for _, name := range stats.Reappeared {
+		removed := reappeared[name]
+		writeWarnFields(name+" has reappeared since it was removed on "+removed, logFields{"computer": name, "action": "reappeared", "removed": removed})
+	}
+	return nil
+}
+
+// Whether a computer missing from the database should be left out rather than added again, because it reappeared
+// and reappearance.action is warn
+func holdReappeared(name string) bool {
+	removed, ok := reappeared[normalize(name)]
+	if !ok || !strings.EqualFold(config.Reappearance.Action, "warn") {
+		return false
+	}
+	recordDecision(name, "reappeared", "removed on "+removed+", not added again until checked")
+	return true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	cfg "github.com/venutios/polarissync/config"
+)
+
+// Held while a sync is running so a reload waits until the run has finished with the old settings
+var configLock sync.Mutex
+
+// Reload the config on SIGHUP or whenever the config file changes, for processes that stay running between syncs
+func watchConfig(configFile string, profile string, tenant string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			writeInfo("SIGHUP received, reloading config")
+			reloadConfig(configFile, profile, tenant)
+		}
+	}()
+
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		writeInfo("Config file changed, reloading config")
+		reloadConfig(configFile, profile, tenant)
+	})
+	viper.WatchConfig()
+}
+
+// Load the config again and log what changed. A config that fails to load is reported and the previous one is kept
+func reloadConfig(configFile string, profile string, tenant string) {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	previous := config
+	if err := loadConfig(configFile, profile, tenant); err != nil {
+		config = previous
+		writeWarn("Config reload failed, keeping the previous config: " + err.Error())
+		return
+	}
+
+	changes := diffConfig(previous, config)
+	if len(changes) == 0 {
+		writeInfo("Config reloaded, no changes")
+		return
+	}
+	for _, c := range changes {
+		writeInfo("Config changed: " + c)
+	}
+}
+
+// Describe each setting that differs between two configs, without showing secrets
+func diffConfig(before cfg.Configuration, after cfg.Configuration) []string {
+	old := map[string]string{}
+	current := map[string]string{}
+	configValues(reflect.ValueOf(before), "", old)
+	configValues(reflect.ValueOf(after), "", current)
+
+	changes := []string{}
+	for key, value := range current {
+		if old[key] == value {
+			continue
+		}
+		if len(secretValues(key, old[key])) > 0 || len(secretValues(key, value)) > 0 {
+			changes = append(changes, key+" changed")
+		} else {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", key, old[key], value))
+		}
+	}
+	//Map entries can be removed as well as changed
+	for key := range old {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, key+" removed")
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// Flatten a config into dotted keys and printable values. Each entry of a map, such as webhook.headers, gets a key
+// of its own so a header can be treated as a secret without hiding the rest
+func configValues(v reflect.Value, prefix string, values map[string]string) {
+	for i := 0; i < v.NumField(); i++ {
+		key := prefix + strings.ToLower(v.Type().Field(i).Name)
+		switch field := v.Field(i); field.Kind() {
+		case reflect.Struct:
+			configValues(field, key+".", values)
+		case reflect.Map:
+			iter := field.MapRange()
+			for iter.Next() {
+				values[key+"."+strings.ToLower(fmt.Sprint(iter.Key().Interface()))] = fmt.Sprint(iter.Value().Interface())
+			}
+		default:
+			values[key] = fmt.Sprint(field.Interface())
+		}
+	}
+}
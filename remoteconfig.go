@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Fetch the shared settings named by remoteConfig.url and merge them over the local config file. The last good copy
+// is cached so a branch server still runs when the central store can't be reached. Azure Blob and S3 objects are
+// read over https, so a private container or bucket needs a SAS or presigned URL
+func mergeRemoteConfig() error {
+	location := viper.GetString("remoteconfig.url")
+	checksum := strings.ToLower(viper.GetString("remoteconfig.sha256"))
+
+	//s3://bucket/key is shorthand for the bucket's https endpoint
+	if strings.HasPrefix(location, "s3://") {
+		location = "https://" + strings.Replace(strings.TrimPrefix(location, "s3://"), "/", ".s3.amazonaws.com/", 1)
+	}
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "https" {
+		return fmt.Errorf("remote config url must be https: %s", location)
+	}
+
+	format := strings.TrimPrefix(path.Ext(u.Path), ".")
+	if format == "" {
+		format = "json"
+	}
+
+	cacheFile := viper.GetString("remoteconfig.cachefile")
+	if cacheFile == "" {
+		cacheFile = filepath.Join(filepath.Dir(viper.ConfigFileUsed()), "remoteconfig.cache")
+	}
+
+	data, err := downloadRemoteConfig(location, checksum)
+	if err == nil {
+		if werr := os.WriteFile(cacheFile, data, 0600); werr != nil {
+			//Logging isn't set up until the config is loaded
+			fmt.Fprintln(os.Stderr, "Unable to cache remote config: "+werr.Error())
+		}
+	} else {
+		//Fall back to the cached copy, which has to pass the same checksum
+		cached, cerr := os.ReadFile(cacheFile)
+		if cerr != nil {
+			return fmt.Errorf("unable to download remote config and no cached copy is available: %w", err)
+		}
+		if cerr = verifyChecksum(cached, checksum); cerr != nil {
+			return fmt.Errorf("unable to download remote config and the cached copy is invalid: %w", cerr)
+		}
+		fmt.Fprintln(os.Stderr, "Using cached remote config: "+err.Error())
+		data = cached
+	}
+
+	remote := viper.New()
+	remote.SetConfigType(format)
+	if err = remote.ReadConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("remote config is corrupt: %w", err)
+	}
+	return viper.MergeConfigMap(remote.AllSettings())
+}
+
+func downloadRemoteConfig(location string, checksum string) ([]byte, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote config returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyChecksum(data, checksum); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Compare the sha256 of the data with the configured value, when one is configured
+func verifyChecksum(data []byte, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); actual != checksum {
+		return fmt.Errorf("remote config checksum %s does not match the expected %s", actual, checksum)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/venutios/polarissync/syncengine"
+)
+
+// The outcome of deleting one workstation
+type removal struct {
+	name       string
+	start, end time.Time
+	err        error
+}
+
+// Remove the computers with removals.workers deletes running at once, starting no more than removals.perSecond, so
+// a big clean up finishes quickly without overloading the server. Results are recorded here rather than by the
+// workers. Returns how many were removed
+func removeComputers(names []string) (int, error) {
+	//The backend is shared by the workers, each running one delete at a time
+	db, err := openDatabase(true)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	workers := config.Removals.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan string)
+	results := make(chan removal)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				results <- deleteWorkstation(db, name)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		var tick <-chan time.Time
+		if config.Removals.PerSecond > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / config.Removals.PerSecond))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for i, name := range names {
+			//Each delete is a single statement, so stopping between them leaves no computer half removed
+			if isInterrupted() {
+				return
+			}
+			if tick != nil && i > 0 {
+				select {
+				case <-tick:
+				case <-interrupted:
+					return
+				}
+			}
+			jobs <- name
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	count := 0
+	removed := newProgress("Removing computers", len(names))
+	for r := range results {
+		removed.add(1)
+		if recordRemoval(r) {
+			count++
+			stats.Removed = count
+		}
+	}
+	return count, nil
+}
+
+// Delete the record from the database
+func deleteWorkstation(db syncengine.Database, name string) removal {
+	r := removal{name: name, start: time.Now()}
+	//Deleting again is harmless, so unlike adding a failed delete can be retried
+	r.err = withRetry("Removing "+name, func() error {
+		return db.DeleteWorkstation(name)
+	})
+	r.end = time.Now()
+	return r
+}
+
+// Record the outcome of a delete in the stats, the trace and the log. Returns whether the computer was removed
+func recordRemoval(r removal) bool {
+	if r.err != nil {
+		recordSpan("delete workstation", r.start, r.end, r.err.Error(), "computer", r.name)
+		stats.RemoveFailed++
+		stats.addError(fmt.Sprintf("Failed to remove workstation %s: %s", r.name, r.err.Error()))
+		recordDecision(r.name, "remove failed", r.err.Error())
+		writeWarnFields(fmt.Sprintf("Failed to remove workstion %s: %s", r.name, r.err.Error()), logFields{"computer": r.name, "action": "remove", "error": r.err.Error()})
+		return false
+	}
+
+	recordSpan("delete workstation", r.start, r.end, "", "computer", r.name)
+	stats.RemovedComputers = append(stats.RemovedComputers, r.name)
+	recordDecision(r.name, "remove", "not found in any source")
+	writeInfoFields(r.name+" removed from database", logFields{"computer": r.name, "action": "remove"})
+	recordLedger(r.name, r.end)
+	runPostRemoveHook(r.name)
+	return true
+}
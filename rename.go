@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A workstation whose computer was renamed in the directory
+type rename struct {
+	oldName string
+	newName string
+	//Where the rename came from when it wasn't listed in the config, e.g. "detected from the objectGUID"
+	origin string
+}
+
+// The rename of a database computer renamed in the directory, from the renames in the config, those added with the
+// renames command or those detected from the objectGUID. A rename only applies when the old name is missing from the
+// directory and the new one from the database, anything else means the rename is stale or not finished yet
+func renamedTo(name string, stored map[string]string, detected map[string]string) (rename, bool) {
+	for oldName, newName := range config.Renames {
+		if normalize(oldName) == normalize(name) && matcher.Rename(name, newName) {
+			return rename{name, normalize(newName), ""}, true
+		}
+	}
+	if newName, ok := stored[normalize(name)]; ok && matcher.Rename(name, newName) {
+		return rename{name, newName, "added with the renames command"}, true
+	}
+	if newName, ok := detected[normalize(name)]; ok && matcher.Rename(name, newName) {
+		return rename{name, newName, "detected from the objectGUID"}, true
+	}
+	return rename{}, false
+}
+
+// The reason recorded for a rename
+func (r rename) reason() string {
+	if r.origin != "" {
+		return "renamed to " + r.newName + ", " + r.origin
+	}
+	return "renamed to " + r.newName
+}
+
+// The renames of a tenant added with the renames command, old name to new name by normalized name. There are none
+// without a history
+func storedRenames(tenant string) (map[string]string, error) {
+	renames := map[string]string{}
+	if config.History.File == "" {
+		return renames, nil
+	}
+	db, err := openHistory()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the history: %w", err)
+	}
+	defer db.Close()
+	rows, err := db.Query("select old_name, new_name from renames where tenant = ?", tenant)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the renames: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oldName, newName string
+		if err := rows.Scan(&oldName, &newName); err != nil {
+			return nil, fmt.Errorf("unable to read the renames: %w", err)
+		}
+		renames[oldName] = newName
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read the renames: %w", err)
+	}
+	return renames, nil
+}
+
+// Rename the workstations in the database, keeping their id, branch and statistics
+func renameComputers(renames []rename) error {
+	if len(renames) == 0 {
+		return nil
+	}
+	if reportOnly {
+		for _, r := range renames {
+			recordDecision(r.oldName, "would rename", r.reason())
+		}
+		writeInfoFields(strconv.Itoa(len(renames))+" computers would be renamed in database", logFields{"action": "rename", "count": len(renames)})
+		return nil
+	}
+
+	db, err := openDatabase(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, r := range renames {
+		if err := checkInterrupted(); err != nil {
+			return err
+		}
+		span := startSpan("rename workstation", "computer", r.oldName)
+		err := withRetry("Renaming "+r.oldName, func() error {
+			return db.RenameWorkstation(r.oldName, r.newName)
+		})
+		if err != nil {
+			span.fail(err.Error())
+			span.finish()
+			stats.RenameFailed++
+			stats.addError(fmt.Sprintf("Failed to rename workstation %s to %s: %s", r.oldName, r.newName, err.Error()))
+			recordDecision(r.oldName, "rename failed", err.Error())
+			writeWarnFields(fmt.Sprintf("Failed to rename workstation %s to %s: %s", r.oldName, r.newName, err.Error()), logFields{"computer": r.oldName, "action": "rename", "error": err.Error()})
+			continue
+		}
+		span.finish()
+		stats.Renamed++
+		recordDecision(r.oldName, "rename", r.reason())
+		writeInfoFields(r.oldName+" renamed to "+r.newName+" in database", logFields{"computer": r.oldName, "action": "rename", "newName": r.newName})
+	}
+	writeInfoFields(strconv.Itoa(stats.Renamed)+" computers renamed in database", logFields{"action": "rename", "count": stats.Renamed})
+	return nil
+}
+
+// List, add or remove the renames kept in the history, for renames that are easier to record as they happen than
+// to add to the config
+func runRenamesCommand(args []string) int {
+	fs := flag.NewFlagSet("renames", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: polarissync renames [flags] list | add <old name> <new name> | remove <old name>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	if config.History.File == "" {
+		fmt.Fprintln(os.Stderr, "Renames are kept in the run history, set history.file in the config")
+		return exitFatal
+	}
+	db, err := openHistory()
+	if err != nil {
+		return exitWithError(fmt.Errorf("unable to open the history: %w", err))
+	}
+	defer db.Close()
+
+	switch fs.Arg(0) {
+	case "list":
+		rows, err := db.Query("select old_name, new_name, added_by, added_time from renames where tenant = ?", tenantName)
+		if err != nil {
+			return exitWithError(fmt.Errorf("unable to read the renames: %w", err))
+		}
+		defer rows.Close()
+		lines := []string{}
+		for rows.Next() {
+			var oldName, newName, by, at string
+			if err := rows.Scan(&oldName, &newName, &by, &at); err != nil {
+				return exitWithError(fmt.Errorf("unable to read the renames: %w", err))
+			}
+			lines = append(lines, fmt.Sprintf("%-20s %-20s added by %s on %s", oldName, newName, by, at))
+		}
+		if err = rows.Err(); err != nil {
+			return exitWithError(fmt.Errorf("unable to read the renames: %w", err))
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	case "add":
+		if fs.NArg() != 3 {
+			fs.Usage()
+			return exitUsage
+		}
+		by := "unknown"
+		if u, err := user.Current(); err == nil {
+			by = u.Username
+		}
+		oldName, newName := normalize(fs.Arg(1)), normalize(fs.Arg(2))
+		_, err = db.Exec("insert or replace into renames values (?,?,?,?,?)", tenantName, oldName, newName, by,
+			clock.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			return exitWithError(fmt.Errorf("unable to save the rename: %w", err))
+		}
+		fmt.Printf("%s will be renamed to %s\n", oldName, newName)
+	case "remove":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return exitUsage
+		}
+		for _, name := range fs.Args()[1:] {
+			result, err := db.Exec("delete from renames where tenant = ? and old_name = ?", tenantName, normalize(name))
+			if err != nil {
+				return exitWithError(fmt.Errorf("unable to remove the rename: %w", err))
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				return exitWithError(fmt.Errorf("%s has no rename", name))
+			}
+			fmt.Printf("Removed the rename of %s\n", normalize(name))
+		}
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+)
+
+var htmlReport = template.Must(template.New("report").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Segoe UI, Helvetica, Arial, sans-serif; color: #222; margin: 2em; }
+h1 { font-size: 1.4em; padding: .4em .6em; border-left: 6px solid {{.Color}}; }
+h2 { font-size: 1.1em; margin-top: 1.5em; }
+h3 { font-size: 1em; margin: 1em 0 .3em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { text-align: left; padding: .3em .8em; border-bottom: 1px solid #ddd; }
+th { background: #f4f4f4; }
+td.number { text-align: right; }
+.error { color: #b00020; }
+.meta { color: #666; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Run {{.RunId}}{{if .Tenant}} for {{.Tenant}}{{end}}, started {{.Start.Format "2006-01-02 15:04:05"}}, took {{.Duration}}. {{.Version}}</p>
+{{if .Fatal}}<p class="error">The run was stopped by an error: {{.Fatal}}</p>{{end}}
+{{if .Tripped}}<p class="error">No computers were removed: {{.Tripped}}</p>{{end}}
+{{if .LicenseAlert}}<p class="error">License alert: {{.LicenseAlert}}</p>{{end}}
+
+<h2>Summary</h2>
+<table>
+<tr><th>Source</th><th>Computers</th></tr>
+{{range .Sources}}<tr><td>{{.Name}}</td><td class="number">{{.Count}}</td></tr>
+{{end}}</table>
+<table>
+<tr><td>Orphans found</td><td class="number">{{.Orphans}}</td></tr>
+<tr><td>Exempt</td><td class="number">{{.Exempt}}</td></tr>
+<tr><td>Removed</td><td class="number">{{.Removed}}</td></tr>
+<tr><td>Failed to remove</td><td class="number">{{.RemoveFailed}}</td></tr>
+<tr><td>Added</td><td class="number">{{.Added}}</td></tr>
+<tr><td>Failed to add</td><td class="number">{{.AddFailed}}</td></tr>
+{{if .Licenses}}<tr><td>Workstations in Polaris</td><td class="number">{{.Workstations}} of {{.Licenses}} licensed</td></tr>
+<tr><td>License utilization</td><td class="number">{{printf "%.0f%%" .LicenseUsage}}</td></tr>{{end}}
+</table>
+
+{{if .ReportOnly}}{{if .OrphanComputers}}<h2>Orphans, not removed as this is a report</h2>
+{{range group .OrphanComputers}}{{if .Name}}<h3>{{.Name}}</h3>{{end}}
+<table>
+{{range .Computers}}<tr><td>{{.}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}{{end}}
+
+{{if .RemovedComputers}}<h2>Removed</h2>
+{{range group .RemovedComputers}}{{if .Name}}<h3>{{.Name}}</h3>{{end}}
+<table>
+{{range .Computers}}<tr><td>{{.}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+
+{{if .ExemptComputers}}<h2>Skipped as exempt</h2>
+<table>
+{{range .ExemptComputers}}<tr><td>{{.}}</td></tr>
+{{end}}</table>{{end}}
+
+{{if .AddedComputers}}<h2>Added</h2>
+<table>
+{{range .AddedComputers}}<tr><td>{{.}}</td></tr>
+{{end}}</table>{{end}}
+
+{{if .Errors}}<h2>Errors</h2>
+<table>
+{{range .Errors}}<tr><td class="error">{{.}}</td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+// Render the results of the run as a single html page with inline styles, so it can be mailed or put on a share. A
+// report template gets the same values as the built in page
+func writeHTMLReport(w io.Writer) error {
+	type source struct {
+		Name  string
+		Count int
+	}
+	sources := []source{}
+	for name, count := range stats.Sources {
+		sources = append(sources, source{name, count})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+
+	color := "#2e7d32"
+	if stats.alerting() {
+		color = "#b00020"
+	} else if len(stats.Errors) > 0 {
+		color = "#f9a825"
+	}
+
+	data := struct {
+		templateData
+		Version      string
+		Color        string
+		Sources      []source
+		LicenseUsage float64
+	}{currentTemplateData(), versionString(), color, sources, stats.licenseUsage()}
+
+	custom, err := readTemplate(config.Report.Template, config.Report.TemplateFile)
+	if err != nil {
+		return err
+	}
+	if custom == "" {
+		return htmlReport.Execute(w, data)
+	}
+	tmpl, err := template.New("report").Funcs(templateFuncs).Parse(custom)
+	if err != nil {
+		return fmt.Errorf("invalid report template: %w", err)
+	}
+	return tmpl.Execute(w, data)
+}
+
+func writeHTMLFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = writeHTMLReport(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
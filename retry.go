@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Run fn until it succeeds or the configured attempts run out, doubling the wait after each failure, so a short
+// network outage doesn't stop the run. Anything other than a timeout or a lost connection is returned at once. The
+// error of the last attempt is returned
+func withRetry(operation string, fn func() error) error {
+	attempts := config.Retry.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := config.Retry.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= attempts || isInterrupted() || !isTransientError(err) {
+			return err
+		}
+
+		wait := delay
+		//Spread the retries of runs that failed together, e.g. every tenant losing the same server
+		if config.Retry.Jitter && wait > 1 {
+			wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)))
+		}
+		writeWarnFields(fmt.Sprintf("%s failed, retrying in %s (attempt %d of %d): %s", operation, wait.Round(time.Millisecond), attempt, attempts, err.Error()),
+			logFields{"action": "retry", "error": err.Error()})
+		select {
+		case <-interrupted:
+			return err
+		case <-time.After(wait):
+		}
+
+		delay *= 2
+		if config.Retry.MaxBackoff > 0 && delay > config.Retry.MaxBackoff {
+			delay = config.Retry.MaxBackoff
+		}
+	}
+}
+
+// Whether a failure could clear up by itself: a timeout, a refused or dropped connection, or a busy server. A wrong
+// password, a missing permission or an invalid DN fails the same way every time, and retrying a bind with a wrong
+// password only brings the service account closer to being locked out
+func isTransientError(err error) bool {
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) {
+		switch ldapErr.ResultCode {
+		case ldap.ErrorNetwork, ldap.LDAPResultBusy, ldap.LDAPResultUnavailable, ldap.LDAPResultTimeLimitExceeded,
+			ldap.LDAPResultServerDown, ldap.LDAPResultTimeout, ldap.LDAPResultConnectError:
+			return true
+		}
+		return false
+	}
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) {
+		switch sqlErr.Number {
+		//Deadlock victim, and the Azure SQL errors for a database that is moving or throttling
+		case 1205, 40197, 40501, 40613, 49918, 49919, 49920:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	//Failures of the powershell behind the Azure source only come back as text
+	text := strings.ToLower(err.Error())
+	for _, s := range []string{"timed out", "timeout", "temporarily unavailable", "connection was closed", "unable to connect", "network"} {
+		if strings.Contains(text, s) {
+			return true
+		}
+	}
+	return false
+}
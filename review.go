@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// The order and ANSI colour of each decision in the terminal review: red for removals, yellow for computers kept
+// by an exemption, a safety limit or a removal check, grey for those a rule ignores, green for computers found in the directory, blue
+// for renames and cyan for additions
+var reviewDecisions = []struct {
+	decision string
+	color    string
+}{
+	{"remove", "31"},
+	{"would remove", "31"},
+	{"remove failed", "1;31"},
+	{"skip", "33"},
+	{"defer", "33"},
+	{"pending", "33"},
+	{"vetoed", "33"},
+	{"reappeared", "33"},
+	{"exempt", "33"},
+	{"ignore", "90"},
+	{"keep", "32"},
+	{"rename", "34"},
+	{"would rename", "34"},
+	{"rename failed", "1;34"},
+	{"add", "36"},
+	{"would add", "36"},
+	{"add failed", "1;36"},
+}
+
+// Whether the review should be printed, only when someone is watching the console
+func reviewWanted() bool {
+	return outputFormat == "text" && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// Print the decisions of the run as aligned columns grouped by decision, coloured when the console supports it.
+// Unlike the log, this is for reading through at the end of a manual run
+func writeTerminalReview(w io.Writer, color bool) {
+	if len(stats.Decisions) == 0 {
+		return
+	}
+	byDecision := map[string][]decision{}
+	widths := []int{len("DECISION"), len("COMPUTER"), len("SOURCES")}
+	for _, d := range stats.Decisions {
+		byDecision[d.Decision] = append(byDecision[d.Decision], d)
+		for i, v := range []string{d.Decision, d.Computer, strings.Join(d.Sources, ",")} {
+			if len(v) > widths[i] {
+				widths[i] = len(v)
+			}
+		}
+	}
+
+	line := func(colorCode string, values ...string) {
+		text := fmt.Sprintf("%-*s  %-*s  %-*s  %s", widths[0], values[0], widths[1], values[1], widths[2], values[2], values[3])
+		if color && colorCode != "" {
+			text = "\x1b[" + colorCode + "m" + text + "\x1b[0m"
+		}
+		fmt.Fprintln(w, text)
+	}
+
+	fmt.Fprintln(w)
+	line("1", "DECISION", "COMPUTER", "SOURCES", "REASON")
+	for _, r := range reviewDecisions {
+		list := byDecision[r.decision]
+		sort.Slice(list, func(i, j int) bool { return list[i].Computer < list[j].Computer })
+		for _, d := range list {
+			line(r.color, d.Decision, d.Computer, strings.Join(d.Sources, ","), d.Reason)
+		}
+	}
+}
+
+// Whether colours should be used on the console, following the NO_COLOR convention
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return enableVirtualTerminal(f)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/venutios/polarissync/polarisdb"
+)
+
+// What a name rule does with the computers it matches
+const (
+	//Removed and added like any other computer, e.g. to carve an exception out of a broader rule below it
+	policyEligible = "eligible"
+	//Never removed, though still added when missing from the database
+	policyExempt = "exempt"
+	//Left alone entirely, neither removed nor added
+	policyIgnore = "ignore"
+)
+
+// Check every rule has a valid pattern and policy and names a known branch
+func checkNameRules() error {
+	for i, rule := range config.Rules {
+		if _, err := path.Match(strings.ToUpper(rule.Pattern), ""); err != nil || rule.Pattern == "" {
+			return fmt.Errorf("rules[%d] has an invalid pattern %q", i, rule.Pattern)
+		}
+		switch strings.ToLower(rule.Policy) {
+		case policyEligible, policyExempt, policyIgnore:
+		default:
+			return fmt.Errorf("rules[%d] has an unknown policy %q, use eligible, exempt or ignore", i, rule.Policy)
+		}
+	}
+	return nil
+}
+
+// The policy of the first rule matching a computer and a description of the rule, or eligible and an empty string
+// when no rule matches. Patterns such as STAFF-* are matched against the normalized name, and a rule with a branch
+// only applies to the computers of that branch
+func nameRule(name string) (string, string) {
+	name = normalize(name)
+	for _, rule := range config.Rules {
+		if matched, _ := path.Match(strings.ToUpper(rule.Pattern), name); !matched {
+			continue
+		}
+		description := "rule " + rule.Pattern
+		if rule.Branch != "" {
+			if !strings.EqualFold(branchOf(name), rule.Branch) {
+				continue
+			}
+			description += " for branch " + strings.ToUpper(rule.Branch)
+		}
+		return strings.ToLower(rule.Policy), description
+	}
+	return policyEligible, ""
+}
+
+// The abbreviation of the organization a computer belongs to, from the prefix of its name
+func branchOf(name string) string {
+	orgID := polarisdb.OrganizationFor(dbOrganizations, name)
+	for _, org := range dbOrganizations {
+		if org.OrganizationID == orgID {
+			return org.Abbreviation
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Make sure only one run at a time changes the database, e.g. a manual run while the nightly task is running. The
+// lock file guards this machine, the database lock also covers copies running on other servers. Returns a function
+// that releases the locks
+func acquireRunLock() (func(), error) {
+	releases := []func(){}
+	release := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+
+	if config.Lock.File != "" {
+		path := configRelativePath(logFileName(config.Lock.File, tenantName, time.Now()))
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open lock file: %w", err)
+		}
+		if err = lockFile(f); err != nil {
+			f.Close()
+			//Windows doesn't allow reading the locked file
+			if holder, _ := os.ReadFile(path); len(holder) > 0 {
+				return nil, fmt.Errorf("another run is in progress, %s holds %s", string(holder), path)
+			}
+			return nil, fmt.Errorf("another run is in progress, %s is locked", path)
+		}
+		//Record who holds the lock for the error message of the next run
+		f.Truncate(0)
+		f.WriteString("run " + runID + ", pid " + strconv.Itoa(os.Getpid()))
+		releases = append(releases, func() {
+			f.Close()
+		})
+	}
+
+	if config.Lock.Database {
+		db, err := sql.Open("mssql", writeConnString())
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("database connection failed: %w", err)
+		}
+		//The lock belongs to the session, so the same connection is held until the run ends
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			db.Close()
+			release()
+			return nil, fmt.Errorf("database connection failed: %w", err)
+		}
+		resource := "polarissync"
+		if tenantName != "" {
+			resource += "-" + tenantName
+		}
+		var result int
+		err = conn.QueryRowContext(context.Background(), "declare @result int; "+
+			"exec @result = sp_getapplock @Resource = ?, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = 0; "+
+			"select @result", resource).Scan(&result)
+		if err != nil || result < 0 {
+			conn.Close()
+			db.Close()
+			release()
+			if err != nil {
+				return nil, fmt.Errorf("unable to take the database lock: %w", err)
+			}
+			return nil, fmt.Errorf("another run is in progress, the database lock %s is held", resource)
+		}
+		releases = append(releases, func() {
+			conn.ExecContext(context.Background(), "exec sp_releaseapplock @Resource = ?, @LockOwner = 'Session'", resource)
+			conn.Close()
+			db.Close()
+		})
+	}
+	return release, nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func runScheduleCommand(args []string) int {
+	fmt.Fprintln(os.Stderr, "Scheduled tasks are only available on Windows, run polarissync daemon or use cron instead")
+	return exitUsage
+}
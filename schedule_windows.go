@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Create or update the scheduled task that runs the sync every day, or remove it
+func runScheduleCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "install" && args[0] != "uninstall") {
+		fmt.Fprintln(os.Stderr, "Usage: polarissync schedule install|uninstall [flags]")
+		return exitUsage
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("schedule "+action, flag.ContinueOnError)
+	name := fs.String("name", "polarissync", "name of the scheduled task")
+	var cf configFlags
+	var at, user, password *string
+	if action == "install" {
+		cf = addConfigFlags(fs)
+		at = fs.String("time", "02:00", "time of day to run the sync, HH:MM")
+		user = fs.String("user", "SYSTEM", "account to run the task as, e.g. DOMAIN\\svc-polarissync$")
+		password = fs.String("password", "", "password of the account, not needed for SYSTEM or a managed service account")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return flagsExitCode(err)
+	}
+
+	if action == "uninstall" {
+		out, err := exec.Command("schtasks", "/Delete", "/TN", *name, "/F").CombinedOutput()
+		if err != nil {
+			fmt.Printf("Unable to remove the scheduled task: %s\n", strings.TrimSpace(string(out)))
+			return 1
+		}
+		fmt.Printf("Scheduled task %s removed\n", *name)
+		return 0
+	}
+
+	start, err := time.ParseInLocation("15:04", *at, time.Local)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid time %s, use HH:MM\n", *at)
+		return exitUsage
+	}
+	task, err := scheduledTaskXML(cf, start, *user)
+	if err != nil {
+		fmt.Println("Unable to create the scheduled task: " + err.Error())
+		return 1
+	}
+
+	//schtasks can't set the working directory, so the task is defined in xml. /F replaces an existing task
+	file, err := os.CreateTemp("", "polarissync-task-*.xml")
+	if err != nil {
+		fmt.Println("Unable to create the scheduled task: " + err.Error())
+		return 1
+	}
+	defer os.Remove(file.Name())
+	file.Write(task)
+	file.Close()
+
+	createArgs := []string{"/Create", "/TN", *name, "/XML", file.Name(), "/F"}
+	if !strings.EqualFold(*user, "SYSTEM") {
+		createArgs = append(createArgs, "/RU", *user, "/RP", *password)
+	}
+	out, err := exec.Command("schtasks", createArgs...).CombinedOutput()
+	if err != nil {
+		fmt.Printf("Unable to create the scheduled task: %s\n", redact(strings.TrimSpace(string(out))))
+		return 1
+	}
+	fmt.Printf("Scheduled task %s runs every day at %s as %s\n", *name, start.Format("15:04"), *user)
+	return 0
+}
+
+// Build the task definition in the UTF-16 encoding schtasks expects
+func scheduledTaskXML(cf configFlags, start time.Time, user string) ([]byte, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"sync"}
+	workDir := filepath.Dir(exe)
+	if *cf.file != "" {
+		file, err := filepath.Abs(*cf.file)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-config", file)
+		workDir = filepath.Dir(file)
+	}
+	if *cf.profile != "" {
+		args = append(args, "-profile", *cf.profile)
+	}
+	if *cf.tenant != "" {
+		args = append(args, "-tenant", *cf.tenant)
+	}
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t") {
+			args[i] = `"` + arg + `"`
+		}
+	}
+
+	principal := "<UserId>S-1-5-18</UserId><LogonType>ServiceAccount</LogonType>"
+	if !strings.EqualFold(user, "SYSTEM") {
+		principal = "<UserId>" + xmlEscape(user) + "</UserId><LogonType>Password</LogonType>"
+	}
+	now := time.Now()
+	boundary := time.Date(now.Year(), now.Month(), now.Day(), start.Hour(), start.Minute(), 0, 0, time.Local)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Description>Removes workstations from Polaris that are no longer in Active Directory or Azure</Description>
+  </RegistrationInfo>
+  <Triggers>
+    <CalendarTrigger>
+      <StartBoundary>%s</StartBoundary>
+      <ScheduleByDay><DaysInterval>1</DaysInterval></ScheduleByDay>
+    </CalendarTrigger>
+  </Triggers>
+  <Principals>
+    <Principal id="Author">%s<RunLevel>HighestAvailable</RunLevel></Principal>
+  </Principals>
+  <Settings>
+    <MultipleInstancesPolicy>IgnoreNew</MultipleInstancesPolicy>
+    <DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries>
+    <StopIfGoingOnBatteries>false</StopIfGoingOnBatteries>
+    <StartWhenAvailable>true</StartWhenAvailable>
+    <RunOnlyIfNetworkAvailable>true</RunOnlyIfNetworkAvailable>
+    <ExecutionTimeLimit>PT4H</ExecutionTimeLimit>
+  </Settings>
+  <Actions Context="Author">
+    <Exec>
+      <Command>%s</Command>
+      <Arguments>%s</Arguments>
+      <WorkingDirectory>%s</WorkingDirectory>
+    </Exec>
+  </Actions>
+</Task>
+`, boundary.Format("2006-01-02T15:04:05"), principal, xmlEscape(exe), xmlEscape(strings.Join(args, " ")), xmlEscape(workDir))
+
+	//Little endian with a byte order mark
+	encoded := utf16.Encode([]rune(strings.ReplaceAll(b.String(), "\n", "\r\n")))
+	out := []byte{0xFF, 0xFE}
+	for _, c := range encoded {
+		out = append(out, byte(c), byte(c>>8))
+	}
+	return out, nil
+}
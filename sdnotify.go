@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Send a state change such as READY=1 to systemd. Does nothing unless started by a systemd unit with Type=notify
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	//A leading @ is an abstract socket
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		writeDebug("Unable to notify systemd: " + err.Error())
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
+
+// How often the systemd watchdog is pinged, half the interval set by WatchdogSec. 0 when nothing is watching
+var watchdogInterval time.Duration
+
+var (
+	watchdogLock sync.Mutex
+	watchdogLast time.Time
+)
+
+// Start pinging the systemd watchdog, if WatchdogSec is set. The pings come from the scheduler loop, so systemd
+// restarts a daemon whose loop has stopped responding, and from a ticker while a run is in progress
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	//The variable is meant for the main process only
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	watchdogInterval = time.Duration(usec) * time.Microsecond / 2
+	sdWatchdog()
+}
+
+// Tell the systemd watchdog the daemon is still working, at most once every half watchdogInterval
+func sdWatchdog() {
+	if watchdogInterval <= 0 {
+		return
+	}
+	watchdogLock.Lock()
+	defer watchdogLock.Unlock()
+	if time.Since(watchdogLast) < watchdogInterval/2 {
+		return
+	}
+	watchdogLast = time.Now()
+	sdNotify("WATCHDOG=1")
+}
+
+// Keep pinging the systemd watchdog while a run is in progress. A run spends long stretches waiting on hooks, checks,
+// sources or the tenant processes, none of which report progress, and the watchdog would otherwise kill the daemon in
+// the middle of removing computers. Returns the function that stops the pings
+func watchdogDuringRun() func() {
+	if watchdogInterval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(watchdogInterval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				sdWatchdog()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Resolvers for secret references in config values, keyed by the scheme of the reference, e.g. keyvault://
+var secretProviders = map[string]func(ref string) (string, error){
+	"keyvault": resolveKeyVaultSecret,
+	"vault":    resolveVaultSecret,
+	"vault-db": resolveVaultDatabaseSecret,
+	"dpapi":    resolveDPAPISecret,
+	"aws-sm":   resolveAWSSecret,
+	"aws-ssm":  resolveAWSParameter,
+}
+
+// Replace every config value that is a reference to a secret store with the secret itself
+func resolveSecrets() error {
+	return resolveSecretFields(reflect.ValueOf(&config).Elem(), "")
+}
+
+func resolveSecretFields(v reflect.Value, prefix string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		key := prefix + strings.ToLower(v.Type().Field(i).Name)
+		switch field.Kind() {
+		case reflect.Struct:
+			if err := resolveSecretFields(field, key+"."); err != nil {
+				return err
+			}
+		case reflect.String:
+			value := field.String()
+			if isEncryptedValue(value) {
+				plain, err := decryptConfigValue(value)
+				if err != nil {
+					return fmt.Errorf("unable to decrypt %s: %w", key, err)
+				}
+				field.SetString(plain)
+				continue
+			}
+			sep := strings.Index(value, "://")
+			if sep <= 0 {
+				continue
+			}
+			if provider, ok := secretProviders[value[:sep]]; ok {
+				secret, err := provider(value)
+				if err != nil {
+					return fmt.Errorf("unable to resolve the secret for %s: %w", key, err)
+				}
+				field.SetString(secret)
+			}
+		}
+	}
+	return nil
+}
+
+// Send a request and decode the JSON response, treating any status other than 200 as an error
+func doJSONRequest(req *http.Request, result interface{}) error {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Replace every credential from the config that appears in the text, so secrets don't end up in logs or on screen
+func redact(s string) string {
+	values := map[string]string{}
+	configValues(reflect.ValueOf(config), "", values)
+	for key, value := range values {
+		for _, secret := range secretValues(key, value) {
+			s = strings.ReplaceAll(s, secret, "********")
+		}
+	}
+	return s
+}
+
+// The parts of a setting that mustn't be shown: the whole value of a credential or request header, and of a URL
+// carrying a user or a query string such as a SAS token. The password and query of such a URL are returned on their
+// own too, as errors may show the URL in another form
+func secretValues(key string, value string) []string {
+	if value == "" {
+		return nil
+	}
+	if isSecretKey(key) || strings.Contains(key, ".headers.") {
+		return []string{value}
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" || (u.User == nil && u.RawQuery == "") {
+		return nil
+	}
+	secrets := []string{value}
+	if password, ok := u.User.Password(); ok && password != "" {
+		secrets = append(secrets, password)
+	}
+	if u.RawQuery != "" {
+		secrets = append(secrets, u.RawQuery)
+	}
+	return secrets
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Hash of the settings of the run, leaving out credentials, so events from runs with the same config can be grouped
+func configFingerprint() string {
+	values := map[string]string{}
+	configValues(reflect.ValueOf(config), "", values)
+	keys := []string{}
+	for key := range values {
+		if len(secretValues(key, values[key])) == 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s=%s\n", key, values[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Report a run that was stopped by an error, or had computers that failed, to sentry. The stack is only known for
+// a panic, which is passed in as it was recovered
+func sendSentryEvent(stack string) error {
+	dsn, err := url.Parse(config.Sentry.Dsn)
+	if err != nil || dsn.User == nil {
+		return fmt.Errorf("invalid dsn")
+	}
+	project := strings.TrimPrefix(dsn.Path, "/")
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	endpoint := dsn.Scheme + "://" + dsn.Host + prefix + "/api/" + project + "/envelope/"
+
+	level, message := "error", fmt.Sprintf("%d errors during the run", len(stats.Errors))
+	if stats.failed() {
+		level, message = "fatal", stats.Fatal
+	}
+	tags := map[string]string{"runId": runID, "configFingerprint": configFingerprint()}
+	if tenantName != "" {
+		tags["tenant"] = tenantName
+	}
+	event := map[string]interface{}{
+		"event_id":    randomHex(16),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"platform":    "go",
+		"level":       level,
+		"logger":      "polarissync",
+		"release":     "polarissync@" + version,
+		"environment": config.Sentry.Environment,
+		"message":     map[string]string{"formatted": message},
+		"tags":        tags,
+		"contexts": map[string]interface{}{
+			"runtime": map[string]string{"name": "go", "version": runtime.Version()},
+			"os":      map[string]string{"name": runtime.GOOS},
+		},
+		"extra": map[string]interface{}{
+			"sources":      stats.Sources,
+			"orphans":      stats.Orphans,
+			"exempt":       stats.Exempt,
+			"removed":      stats.Removed,
+			"removeFailed": stats.RemoveFailed,
+			"added":        stats.Added,
+			"addFailed":    stats.AddFailed,
+			"errors":       stats.Errors,
+			"stack":        redact(stack),
+		},
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(map[string]string{"event_id": event["event_id"].(string), "dsn": config.Sentry.Dsn})
+	var body bytes.Buffer
+	body.Write(header)
+	body.WriteString("\n" + `{"type":"event","length":` + fmt.Sprint(len(payload)) + "}\n")
+	body.Write(payload)
+
+	req, err := http.NewRequest("POST", endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=polarissync/"+version+", sentry_key="+dsn.User.Username())
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sentry returned %s", resp.Status)
+	}
+	return nil
+}
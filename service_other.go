@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func runServiceCommand(args []string) int {
+	fmt.Fprintln(os.Stderr, "Windows services are only available on Windows, run polarissync daemon under systemd instead")
+	return exitUsage
+}
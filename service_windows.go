@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Install, remove, start or stop the Windows service, or run as the service when started by the service control
+// manager
+func runServiceCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: polarissync service install|uninstall|start|stop [flags]")
+		return exitUsage
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("service "+action, flag.ContinueOnError)
+	name := fs.String("name", "polarissync", "name of the windows service")
+	var cf configFlags
+	var user, password *string
+	switch action {
+	case "install":
+		cf = addConfigFlags(fs)
+		user = fs.String("user", "", "account to run the service as, e.g. DOMAIN\\svc-polarissync$ (default LocalSystem)")
+		password = fs.String("password", "", "password of the account, not needed for a managed service account")
+	case "run":
+		cf = addConfigFlags(fs)
+	case "uninstall", "start", "stop":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown service action %s\n", action)
+		return exitUsage
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return flagsExitCode(err)
+	}
+
+	if action == "run" {
+		return runService(*name, cf)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		fmt.Println("Unable to connect to the service control manager: " + err.Error())
+		return 1
+	}
+	defer m.Disconnect()
+
+	switch action {
+	case "install":
+		err = installService(m, *name, cf, *user, *password)
+	case "uninstall":
+		err = uninstallService(m, *name)
+	case "start", "stop":
+		var s *mgr.Service
+		if s, err = m.OpenService(*name); err == nil {
+			if action == "start" {
+				err = s.Start()
+			} else {
+				_, err = s.Control(svc.Stop)
+			}
+			s.Close()
+		}
+	}
+	if err != nil {
+		fmt.Printf("Unable to %s the %s service: %s\n", action, *name, err.Error())
+		return 1
+	}
+	fmt.Printf("Service %s: %s done\n", *name, action)
+	return 0
+}
+
+// Register the service to run the daemon at startup with the given config flags
+func installService(m *mgr.Mgr, name string, cf configFlags, user string, password string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	//The service starts in the system folder, so the config is passed with its full path
+	args := []string{"service", "run", "-name", name}
+	if *cf.file != "" {
+		file, err := filepath.Abs(*cf.file)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-config", file)
+	}
+	if *cf.profile != "" {
+		args = append(args, "-profile", *cf.profile)
+	}
+	if *cf.tenant != "" {
+		args = append(args, "-tenant", *cf.tenant)
+	}
+	if *cf.verbose {
+		args = append(args, "-verbose")
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName:      "Polaris workstation sync",
+		Description:      "Removes workstations from Polaris that are no longer in Active Directory or Azure",
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+		ServiceStartName: user,
+		Password:         password,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	//Restart after a crash, the daemon itself survives failed runs
+	s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Minute},
+	}, uint32((24 * time.Hour).Seconds()))
+
+	if err = eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("unable to register the event log source: %w", err)
+	}
+	return nil
+}
+
+func uninstallService(m *mgr.Mgr, name string) error {
+	s, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err = s.Delete(); err != nil {
+		return err
+	}
+	eventlog.Remove(name)
+	return nil
+}
+
+// Run the daemon under the service control manager
+func runService(name string, cf configFlags) int {
+	//Relative paths in the config, such as the log folder, are taken from the folder of the executable rather than
+	//the system folder the service starts in
+	if exe, err := os.Executable(); err == nil {
+		os.Chdir(filepath.Dir(exe))
+	}
+	elog, err := eventlog.Open(name)
+	if err != nil {
+		return exitFatal
+	}
+	defer elog.Close()
+
+	if err = svc.Run(name, &daemonService{flags: cf, elog: elog}); err != nil {
+		elog.Error(1, "Service failed: "+err.Error())
+		return exitFatal
+	}
+	return 0
+}
+
+type daemonService struct {
+	flags configFlags
+	elog  *eventlog.Log
+}
+
+func (s *daemonService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	done := make(chan int, 1)
+	go func() {
+		//A config that fails to load stops the service, the event log is the only place to report it
+		if err := s.flags.load(); err != nil {
+			s.elog.Error(1, redact(err.Error()))
+			done <- exitFatal
+			return
+		}
+		done <- runDaemon(s.flags)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	s.elog.Info(1, "Service started with "+versionString())
+	for {
+		select {
+		case code := <-done:
+			return true, uint32(code)
+		case c := <-requests:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				requestDaemonStop()
+				interruptRun()
+				//A run in progress stops at the next safe point, keep telling the service manager we are still stopping
+				checkpoint := uint32(1)
+				for {
+					status <- svc.Status{State: svc.StopPending, CheckPoint: checkpoint, WaitHint: 30000}
+					select {
+					case <-done:
+						s.elog.Info(1, "Service stopped")
+						return false, 0
+					case <-time.After(10 * time.Second):
+						checkpoint++
+					}
+				}
+			}
+		}
+	}
+}
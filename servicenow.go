@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// File a record in servicenow listing the workstations removed by the run, as required for deletions of production
+// data. Uses the table api, so the table can be change_request, incident or any other ticket table
+func createServiceNowRecord() error {
+	sn := config.ServiceNow
+	record := map[string]string{
+		"short_description": fmt.Sprintf("polarissync removed %d workstations from Polaris", stats.Removed),
+		"description":       summaryText(),
+	}
+	if tenantName != "" {
+		record["short_description"] += " (" + tenantName + ")"
+	}
+	if sn.AssignmentGroup != "" {
+		record["assignment_group"] = sn.AssignmentGroup
+	}
+	for k, v := range sn.Fields {
+		record[k] = v
+	}
+
+	auth := base64.StdEncoding.EncodeToString([]byte(sn.Username + ":" + sn.Password))
+	return postJSON(strings.TrimRight(sn.Instance, "/")+"/api/now/table/"+sn.Table, record,
+		map[string]string{"Authorization": "Basic " + auth, "Accept": "application/json"})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var (
+	//Closed when the run should stop at the next safe point
+	interrupted   = make(chan struct{})
+	interruptOnce sync.Once
+)
+
+func interruptRun() {
+	interruptOnce.Do(func() {
+		close(interrupted)
+	})
+}
+
+func isInterrupted() bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returned by a run stopped part way by an interrupt
+var errInterrupted = errors.New("run interrupted before it finished")
+
+// Check whether the run has been interrupted. Called between steps, so the run ends with its summary written, the
+// locks released and the connections closed rather than in the middle of a statement
+func checkInterrupted() error {
+	if isInterrupted() {
+		return errInterrupted
+	}
+	return nil
+}
+
+// Stop at the next safe point on SIGINT or SIGTERM, calling onStop first if given. A second signal exits at once
+func handleSignals(onStop func()) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		writeWarn("Interrupted, stopping after the current step. Interrupt again to exit immediately")
+		if onStop != nil {
+			onStop()
+		}
+		interruptRun()
+		<-signals
+		writeWarn("Interrupted again, exiting without finishing the run")
+		os.Exit(exitFatal)
+	}()
+}
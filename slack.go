@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Post the run summary to slack, through an incoming webhook or as a bot with chat.postMessage. Runs with errors go
+// to the error webhook or channel when one is configured
+func sendSlackNotification() error {
+	s := config.Slack
+	problems := stats.failed() || len(stats.Errors) > 0
+
+	icon := ":white_check_mark:"
+	if stats.failed() {
+		icon = ":x:"
+	} else if problems || stats.LicenseAlert != "" {
+		icon = ":warning:"
+	}
+	blocks := []interface{}{
+		map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": icon + " *" + summaryTitle() + "*"}},
+		map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": "```" + summaryText() + "```"}},
+	}
+	payload := map[string]interface{}{"text": summaryTitle(), "blocks": blocks}
+
+	if s.Token == "" {
+		url := s.WebhookUrl
+		if problems && s.ErrorWebhookUrl != "" {
+			url = s.ErrorWebhookUrl
+		}
+		return postJSON(url, payload, nil)
+	}
+
+	payload["channel"] = s.Channel
+	if problems && s.ErrorChannel != "" {
+		payload["channel"] = s.ErrorChannel
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", "https://slack.com/api/chat.postMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+
+	//The api answers 200 even when the message is rejected, the outcome is in the body
+	var result struct {
+		Ok    bool
+		Error string
+	}
+	if err = doJSONRequest(req, &result); err != nil {
+		return err
+	}
+	if !result.Ok {
+		return fmt.Errorf("slack rejected the message: %s", strings.ReplaceAll(result.Error, "_", " "))
+	}
+	return nil
+}
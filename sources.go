@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The directory sources enabled in the config
+func enabledSources() []string {
+	sources := []string{}
+	if config.ActiveDirectory.Enabled {
+		sources = append(sources, "ad")
+	}
+	if config.Azure.Enabled {
+		sources = append(sources, "azure")
+	}
+	return sources
+}
+
+// Load a directory source, applying sources.onFailure when it fails: abort the run, continue with the other
+// sources, or continue without changing the database. Continuing still adds and renames, but nothing is removed as
+// the computers only in the failed source would look orphaned
+func loadSource(name string, load func() error) error {
+	err := load()
+	if err == nil {
+		sourcesRead[name] = clock.Now()
+	}
+	policy := strings.ToLower(config.Sources.OnFailure)
+	//An interrupt still stops the run whatever the policy
+	if err == nil || errors.Is(err, errInterrupted) || (policy != "continue" && policy != "report") {
+		return err
+	}
+
+	stats.FailedSources = append(stats.FailedSources, name)
+	stats.addError(fmt.Sprintf("Source %s failed: %s", name, err.Error()))
+	if policy == "report" {
+		reportOnly = true
+		writeWarnFields("Source "+name+" failed, continuing without changing the database: "+err.Error(), logFields{"source": name, "error": err.Error()})
+	} else {
+		writeWarnFields("Source "+name+" failed, continuing with the other sources without removing any computers: "+err.Error(), logFields{"source": name, "error": err.Error()})
+	}
+	return nil
+}
+
+// Check each source returned at least safety.minComputers, either a count or a percentage of the last good run.
+// Returns why removals should not go ahead, or an empty string
+func checkSourceCounts() (string, error) {
+	failed := map[string]bool{}
+	for _, source := range stats.FailedSources {
+		failed[source] = true
+	}
+	sources := []string{}
+	for source := range config.Safety.MinComputers {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	var previous map[string]int
+	for _, source := range sources {
+		count, loaded := stats.Sources[source]
+		if !loaded || failed[source] {
+			continue
+		}
+
+		threshold := strings.TrimSpace(config.Safety.MinComputers[source])
+		minimum, relative, err := parseMinComputers(source, threshold)
+		if err != nil {
+			return "", err
+		}
+		if relative {
+			if previous == nil {
+				//Without the last counts there is no telling whether this run returned too few
+				if previous, err = lastSourceCounts(); err != nil {
+					return fmt.Sprintf("unable to read the computer counts of the last run to check %s against %s: %s", source, threshold, err.Error()), nil
+				}
+			}
+			last, ok := previous[source]
+			if !ok {
+				continue
+			}
+			if count < int(float64(last)*minimum/100) {
+				return fmt.Sprintf("%s returned %d computers, less than %s of the %d from the last run", source, count, threshold, last), nil
+			}
+		} else if count < int(minimum) {
+			return fmt.Sprintf("%s returned %d computers, less than the minimum of %d", source, count, int(minimum)), nil
+		}
+	}
+	return "", nil
+}
+
+// Read a safety.minComputers threshold, a count or a percentage of the last run. Returns the number and whether it
+// is a percentage
+func parseMinComputers(source string, threshold string) (float64, bool, error) {
+	if strings.HasSuffix(threshold, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, true, fmt.Errorf("safety.minComputers.%s is not a valid percentage: %s", source, threshold)
+		}
+		return percent, true, nil
+	}
+	minimum, err := strconv.Atoi(threshold)
+	if err != nil || minimum < 0 {
+		return 0, false, fmt.Errorf("safety.minComputers.%s is not a valid count: %s", source, threshold)
+	}
+	return float64(minimum), false, nil
+}
+
+// Check the safety.minComputers thresholds when the config is loaded, rather than finding a bad one when a run is
+// about to remove computers
+func checkMinComputers() error {
+	for source, threshold := range config.Safety.MinComputers {
+		switch source {
+		case "polaris", "ad", "azure":
+		default:
+			return fmt.Errorf("safety.minComputers has an unknown source %q, use polaris, ad or azure", source)
+		}
+		_, relative, err := parseMinComputers(source, strings.TrimSpace(threshold))
+		if err != nil {
+			return err
+		}
+		if relative && config.History.File == "" {
+			return fmt.Errorf("safety.minComputers.%s is relative to the last run, which needs history.file to be set", source)
+		}
+	}
+	return nil
+}
+
+// Source counts from the most recent run in the history that was not fatal or tripped
+func lastSourceCounts() (map[string]int, error) {
+	counts := map[string]int{}
+	if config.History.File == "" {
+		return counts, fmt.Errorf("thresholds relative to the last run need history.file to be set")
+	}
+	db, err := openHistory()
+	if err != nil {
+		return counts, err
+	}
+	defer db.Close()
+
+	var sources string
+	err = db.QueryRow("select sources from runs where tenant = ? and fatal = '' and tripped = '' order by start_time desc limit 1",
+		tenantName).Scan(&sources)
+	if err == sql.ErrNoRows {
+		return counts, nil
+	}
+	if err != nil {
+		return counts, err
+	}
+	return counts, json.Unmarshal([]byte(sources), &counts)
+}
+
+// Report the exempt computers that are neither in the database nor any source, dead entries that would hide a typo
+// in a name added later. Skipped when a source failed, as its computers would all look stale
+func findStaleExemptions() {
+	if len(stats.FailedSources) > 0 {
+		return
+	}
+	seen := map[string]bool{}
+	for _, name := range config.Database.ExemptComputers {
+		name = normalize(name)
+		if name == "" || seen[name] || len(computerSources[name]) > 0 {
+			continue
+		}
+		seen[name] = true
+		stats.StaleExemptions = append(stats.StaleExemptions, name)
+	}
+	if len(stats.StaleExemptions) > 0 {
+		writeWarnFields("Exempt computers not found in the database or any source: "+strings.Join(stats.StaleExemptions, ", "),
+			logFields{"action": "exempt", "count": len(stats.StaleExemptions)})
+	}
+}
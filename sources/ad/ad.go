@@ -0,0 +1,256 @@
+// Package ad lists the computer objects in Active Directory over LDAP
+package ad
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Where to find the directory and how to sign in to it
+type Options struct {
+	Host string
+	//Bind as the identity the process runs as rather than with Username and Password (Windows only)
+	Trusted  bool
+	Domain   string
+	Username string
+	Password string
+	//The search base, e.g. OU=Computers,DC=library,DC=local
+	BaseDN   string
+	PageSize int
+	//The attribute compared with Polaris, cn when empty
+	Attribute string
+	//Where the recycle bin keeps deleted objects, CN=Deleted Objects under the domain of BaseDN when empty
+	DeletedObjectsDN string
+}
+
+// A plain attribute name, as LDAP allows
+var attributeName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+// The attribute holding the name compared with Polaris
+func (o Options) attribute() (string, error) {
+	if o.Attribute == "" {
+		return "cn", nil
+	}
+	if !attributeName.MatchString(o.Attribute) {
+		return "", fmt.Errorf("invalid attribute %q", o.Attribute)
+	}
+	return o.Attribute, nil
+}
+
+// Open a connection to the AD server and bind with the configured account
+func Connect(o Options) (*ldap.Conn, error) {
+	l, err := ldap.DialURL(fmt.Sprintf("ldap://%s:389", o.Host))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to AD server: %w", err)
+	}
+
+	//A trusted bind uses the identity the process runs as, e.g. a group managed service account
+	if o.Trusted {
+		if err := bindCurrentIdentity(l, o.Host); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("unable to bind to ldap as the current user: %w", err)
+		}
+		return l, nil
+	}
+
+	username := o.Domain + "\\" + o.Username
+
+	if err := l.Bind(username, o.Password); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("unable to bind to ldap: %w", err)
+	}
+	return l, nil
+}
+
+// Pass the name of every computer under the search base to emit, a page at a time as the server returns them, so
+// the whole directory is never held in memory. Returns how many computers were found
+func Computers(o Options, emit func(name string)) (int, error) {
+	return IdentifiedComputers(o, func(name string, id string) {
+		emit(name)
+	})
+}
+
+// Pass the name and objectGUID of every computer under the search base to emit, like Computers. The objectGUID
+// stays the same when a computer is renamed
+func IdentifiedComputers(o Options, emit func(name string, id string)) (int, error) {
+	attribute, err := o.attribute()
+	if err != nil {
+		return 0, err
+	}
+	l, err := Connect(o)
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	//Retrieve only the name attribute for all computer objects
+	filter := "(&(objectClass=computer))"
+	paging := ldap.NewControlPaging(uint32(o.PageSize))
+	searhReq := ldap.NewSearchRequest(o.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, filter, []string{attribute, "objectGUID"}, []ldap.Control{paging})
+	count := 0
+	for {
+		result, err := l.Search(searhReq)
+		if err != nil {
+			return count, fmt.Errorf("ldap search error: %w", err)
+		}
+		for _, x := range result.Entries {
+			emit(x.GetAttributeValue(attribute), FormatGUID(x.GetRawAttributeValue("objectGUID")))
+			count++
+		}
+
+		//An empty cookie means that was the last page
+		control, ok := ldap.FindControl(result.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+		if !ok || len(control.Cookie) == 0 {
+			return count, nil
+		}
+		paging.SetCookie(control.Cookie)
+	}
+}
+
+// Format an objectGUID the way AD tools show it. The first three groups are stored little endian, or an empty
+// string for a value that isn't a GUID
+func FormatGUID(b []byte) string {
+	if len(b) != 16 {
+		return ""
+	}
+	return fmt.Sprintf("%02x%02x%02x%02x-%02x%02x-%02x%02x-%x-%x", b[3], b[2], b[1], b[0], b[5], b[4], b[7], b[6], b[8:10], b[10:])
+}
+
+// Active Directory as a directory source
+type Directory struct {
+	Options Options
+}
+
+func (d Directory) Computers(emit func(name string)) (int, error) {
+	return Computers(d.Options, emit)
+}
+
+func (d Directory) IdentifiedComputers(emit func(name string, id string)) (int, error) {
+	return IdentifiedComputers(d.Options, emit)
+}
+
+// A computer object and when it last signed in to the domain
+type Computer struct {
+	DN              string
+	DNSHostName     string
+	OperatingSystem string
+	Disabled        bool
+	//Replicated between domain controllers only every 9 to 14 days, so it can lag behind the real last logon
+	LastLogon time.Time
+	Created   time.Time
+}
+
+// The computer objects under the search base with the given name in the compared attribute
+func Lookup(o Options, name string) ([]Computer, error) {
+	attribute, err := o.attribute()
+	if err != nil {
+		return nil, err
+	}
+	l, err := Connect(o)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	filter := "(&(objectClass=computer)(" + attribute + "=" + ldap.EscapeFilter(name) + "))"
+	attributes := []string{"dNSHostName", "operatingSystem", "userAccountControl", "lastLogonTimestamp", "whenCreated"}
+	result, err := l.Search(ldap.NewSearchRequest(o.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, filter, attributes, nil))
+	if err != nil {
+		return nil, fmt.Errorf("ldap search error: %w", err)
+	}
+
+	computers := []Computer{}
+	for _, x := range result.Entries {
+		c := Computer{DN: x.DN, DNSHostName: x.GetAttributeValue("dNSHostName"), OperatingSystem: x.GetAttributeValue("operatingSystem")}
+		//Bit 2 of userAccountControl is ACCOUNTDISABLE
+		if flags, err := strconv.Atoi(x.GetAttributeValue("userAccountControl")); err == nil {
+			c.Disabled = flags&2 != 0
+		}
+		//lastLogonTimestamp counts 100 nanosecond intervals since 1601
+		if ticks, err := strconv.ParseInt(x.GetAttributeValue("lastLogonTimestamp"), 10, 64); err == nil && ticks > 0 {
+			c.LastLogon = time.Unix(ticks/10000000-11644473600, 0)
+		}
+		if created, err := time.Parse("20060102150405.0Z", x.GetAttributeValue("whenCreated")); err == nil {
+			c.Created = created
+		}
+		computers = append(computers, c)
+	}
+	return computers, nil
+}
+
+// Pass the name of every computer in the Deleted Objects container, where the AD recycle bin keeps deleted objects
+// until they are purged, with the DN of the container it was deleted from. Deleted objects are only returned with
+// the show deleted control, and their cn has the objectGUID appended, so the name comes from msDS-LastKnownRDN
+func DeletedComputers(o Options, emit func(name string, parent string)) (int, error) {
+	base := o.DeletedObjectsDN
+	if base == "" {
+		base = "CN=Deleted Objects," + domainDN(o.BaseDN)
+	}
+	l, err := Connect(o)
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	filter := "(&(objectClass=computer)(isDeleted=TRUE))"
+	paging := ldap.NewControlPaging(uint32(o.PageSize))
+	showDeleted := ldap.NewControlString("1.2.840.113556.1.4.417", true, "")
+	searchReq := ldap.NewSearchRequest(base, ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false, filter, []string{"msDS-LastKnownRDN", "lastKnownParent"}, []ldap.Control{showDeleted, paging})
+	count := 0
+	for {
+		result, err := l.Search(searchReq)
+		if err != nil {
+			return count, fmt.Errorf("ldap search of deleted objects error: %w", err)
+		}
+		for _, x := range result.Entries {
+			emit(x.GetAttributeValue("msDS-LastKnownRDN"), x.GetAttributeValue("lastKnownParent"))
+			count++
+		}
+
+		control, ok := ldap.FindControl(result.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+		if !ok || len(control.Cookie) == 0 {
+			return count, nil
+		}
+		paging.SetCookie(control.Cookie)
+	}
+}
+
+// The path of the OU a DN names, outermost first and without the domain, e.g. Branches/Central for
+// OU=Central,OU=Branches,DC=library,DC=local
+func OUPath(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return dn
+	}
+	parts := []string{}
+	for _, rdn := range parsed.RDNs {
+		for _, a := range rdn.Attributes {
+			if !strings.EqualFold(a.Type, "DC") {
+				parts = append([]string{a.Value}, parts...)
+			}
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// The domain part of a DN, the DC= components, e.g. DC=library,DC=local for OU=Computers,DC=library,DC=local
+func domainDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return dn
+	}
+	parts := []string{}
+	for _, rdn := range parsed.RDNs {
+		for _, a := range rdn.Attributes {
+			if strings.EqualFold(a.Type, "DC") {
+				parts = append(parts, "DC="+a.Value)
+			}
+		}
+	}
+	return strings.Join(parts, ",")
+}
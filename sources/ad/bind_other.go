@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package ad
+
+import (
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func bindCurrentIdentity(l *ldap.Conn, host string) error {
+	return fmt.Errorf("binding as the current user is only available on windows")
+}
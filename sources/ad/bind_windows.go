@@ -0,0 +1,18 @@
+package ad
+
+import (
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+)
+
+// Bind with kerberos using the credentials of the process through SSPI. The service principal is built from the
+// host, so the host should be the domain controller's DNS name rather than an IP address
+func bindCurrentIdentity(l *ldap.Conn, host string) error {
+	client, err := gssapi.NewSSPIClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return l.GSSAPIBind(client, "ldap/"+host, "")
+}
@@ -0,0 +1,231 @@
+// Package azure lists the Azure AD joined devices through the AzureAD powershell module
+package azure
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// The account used to sign in to Azure AD
+type Options struct {
+	Username string
+	Password string
+	//The Azure AD domain the username belongs to, e.g. library.onmicrosoft.com
+	Domain string
+	//The device property compared with Polaris, DisplayName when empty
+	Property string
+}
+
+// A plain property name, which is all that can be safely put into a script
+var propertyName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// The device property holding the name compared with Polaris
+func (o Options) property() (string, error) {
+	if o.Property == "" {
+		return "DisplayName", nil
+	}
+	if !propertyName.MatchString(o.Property) {
+		return "", fmt.Errorf("invalid device property %q", o.Property)
+	}
+	return o.Property, nil
+}
+
+// Start powershell, sign in to Azure AD and run the given commands, returning everything written to stdout
+func Output(o Options, commands ...string) ([]byte, error) {
+	var out bytes.Buffer
+	err := Stream(o, func(line string) {
+		out.WriteString(line + "\n")
+	}, commands...)
+	return out.Bytes(), err
+}
+
+// Start powershell, sign in to Azure AD and run the given commands, passing each line written to stdout to onLine
+// as it is written
+func Stream(o Options, onLine func(string), commands ...string) error {
+	cmd := exec.Command("powershell", "-nologo", "-noprofile")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer stdin.Close()
+		fmt.Fprintln(stdin, "$userName = "+Quote(o.Username+"@"+o.Domain))
+		fmt.Fprintln(stdin, "$passText = "+Quote(o.Password))
+		fmt.Fprintln(stdin, "$secpasswd = ConvertTo-SecureString -String $passText -AsPlainText -Force")
+		fmt.Fprintln(stdin, "$creds = New-Object System.Management.Automation.PSCredential ($userName, $secpasswd)")
+		fmt.Fprintln(stdin, "Connect-AzureAD -Credential $creds")
+		for _, c := range commands {
+			fmt.Fprintln(stdin, c)
+		}
+	}()
+
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("failed to connect to powershell: %w", err)
+	}
+
+	//stderr is read alongside stdout so powershell never blocks on a full pipe
+	errtxt := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(stderr)
+		errtxt <- b
+	}()
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		onLine(scanner.Text())
+	}
+	//Drain anything left after an overlong line so powershell can exit
+	io.Copy(io.Discard, stdout)
+	stderrText := string(<-errtxt)
+
+	if err = cmd.Wait(); err != nil {
+		//powershell can echo the script back on errors, which includes the password
+		if o.Password != "" {
+			stderrText = strings.ReplaceAll(stderrText, o.Password, "********")
+		}
+		return fmt.Errorf("%s\n%s", err.Error(), stderrText)
+	}
+	return scanner.Err()
+}
+
+// Pass the compared property, normally the display name, of every Azure AD joined device to emit as powershell
+// lists them. Returns how many lines powershell wrote and how many devices were found
+func Devices(o Options, emit func(name string)) (int, int, error) {
+	property, err := o.property()
+	if err != nil {
+		return 0, 0, err
+	}
+	lines, count := 0, 0
+	skip, done := true, false
+	err = Stream(o, func(c string) {
+		lines++
+		if done {
+			return
+		}
+		//Start of the computer records has been found, save each line until a blank line is encountered
+		if !skip {
+			trimmed := strings.TrimSpace(c)
+			if trimmed == "" {
+				done = true
+				return
+			}
+			emit(trimmed)
+			count++
+		} else if strings.HasPrefix(strings.TrimSpace(c), "-----------") {
+			//This line is the dashes right above the list of computers
+			skip = false
+		}
+	}, "Get-AzureADDevice -All $true | Where {($_.DeviceTrustType -eq \"AzureAD\") -and ($_.ProfileType -eq \"RegisteredDevice\")} | Format-Table -Property "+property)
+	return lines, count, err
+}
+
+// Quote a value as a powershell string literal
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Azure AD joined devices as a directory source
+type Directory struct {
+	Options Options
+}
+
+func (d Directory) Computers(emit func(name string)) (int, error) {
+	_, count, err := Devices(d.Options, emit)
+	return count, err
+}
+
+// An Azure AD device, with the values as powershell formats them
+type Device struct {
+	//The value of the compared property, the same as DisplayName unless another property is compared
+	Name        string
+	DisplayName string
+	DeviceID    string
+	TrustType   string
+	ProfileType string
+	OSType      string
+	Enabled     string
+	LastLogon   string
+}
+
+// The devices with the given name in the compared property, whatever their trust and profile types so a device
+// that isn't synced can be seen too
+func Lookup(o Options, name string) ([]Device, error) {
+	property, err := o.property()
+	if err != nil {
+		return nil, err
+	}
+	return listDevices(o, "Where {$_."+property+" -eq "+Quote(name)+"}")
+}
+
+// Every device in Azure AD, whatever its trust and profile types, including the devices registered by their users
+// rather than joined
+func AllDevices(o Options) ([]Device, error) {
+	return listDevices(o, "")
+}
+
+// The devices passing the powershell filter, or every device when it is empty
+func listDevices(o Options, filter string) ([]Device, error) {
+	property, err := o.property()
+	if err != nil {
+		return nil, err
+	}
+	properties := "DisplayName,DeviceId,DeviceTrustType,ProfileType,DeviceOSType,AccountEnabled,ApproximateLastLogonTimeStamp"
+	if property != "DisplayName" {
+		properties += "," + property
+	}
+	command := "Get-AzureADDevice -All $true | "
+	if filter != "" {
+		command += filter + " | "
+	}
+	command += "Format-List -Property " + properties
+
+	devices := []Device{}
+	var current *Device
+	err = Stream(o, func(line string) {
+		//Format-List writes each device as "Property : value" lines, starting with the display name
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if key == "DisplayName" {
+			devices = append(devices, Device{DisplayName: value})
+			current = &devices[len(devices)-1]
+		}
+		if current == nil {
+			return
+		}
+		if key == property {
+			current.Name = value
+		}
+		switch key {
+		case "DeviceId":
+			current.DeviceID = value
+		case "DeviceTrustType":
+			current.TrustType = value
+		case "ProfileType":
+			current.ProfileType = value
+		case "DeviceOSType":
+			current.OSType = value
+		case "AccountEnabled":
+			current.Enabled = value
+		case "ApproximateLastLogonTimeStamp":
+			current.LastLogon = value
+		}
+	}, command)
+	return devices, err
+}
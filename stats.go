@@ -0,0 +1,216 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Counts and timings of a sync run, used for metrics and reporting
+type runStats struct {
+	Start        time.Time
+	End          time.Time
+	Sources      map[string]int
+	Orphans      int
+	Exempt       int
+	Removed      int
+	RemoveFailed int
+	Added        int
+	AddFailed    int
+	Renamed      int
+	RenameFailed int
+	Errors       []string
+	Fatal        string
+	Tripped      string
+	//Workstations in Polaris after the changes of the run, and the licenses configured for them
+	Workstations int
+	Licenses     int
+	//Set when the workstations use more of the licenses than licenses.alertPercent
+	LicenseAlert string
+
+	//Directory sources that failed to load when the policy let the run carry on without them
+	FailedSources []string
+
+	//Names of the computers behind the counts, for reports
+	RemovedComputers []string
+	ExemptComputers  []string
+	AddedComputers   []string
+	OrphanComputers  []string
+	//Orphans waiting out removals.delay, and those first found this run
+	Pending    []string
+	NewPending []string
+	//Orphans a removal check kept for review
+	Deferred []string
+	//Exemption entries for computers that no longer exist anywhere
+	StaleExemptions []string
+	//Computers an earlier run removed that are back
+	Reappeared []string
+	Decisions  []decision
+}
+
+// What the run did with one computer and why
+type decision struct {
+	Computer string
+	Decision string
+	Reason   string
+	Sources  []string
+	Time     time.Time
+}
+
+// The sources each computer was found in, by normalized name
+var computerSources = map[string][]string{}
+
+func addComputerSource(name string, source string) {
+	for _, s := range computerSources[name] {
+		if s == source {
+			return
+		}
+	}
+	computerSources[name] = append(computerSources[name], source)
+}
+
+func recordDecision(name string, action string, reason string) {
+	stats.Decisions = append(stats.Decisions, decision{Computer: name, Decision: action, Reason: redact(reason),
+		Sources: computerSources[normalize(name)], Time: clock.Now()})
+}
+
+var stats = runStats{Sources: map[string]int{}}
+
+// Exit codes of a run, so schedulers and monitoring can tell the outcomes apart
+const (
+	exitSuccess    = 0
+	exitRemoved    = 1
+	exitItemErrors = 2
+	exitFatal      = 3
+	exitTripped    = 4
+	//Invalid flags or arguments, EX_USAGE from sysexits.h so it can't be mistaken for an outcome of a run
+	exitUsage = 64
+)
+
+// Name of the tenant being synced, empty when there is only one library system
+var tenantName string
+
+func (s *runStats) addError(err string) {
+	s.Errors = append(s.Errors, redact(err))
+}
+
+// A run failed when it was stopped by an error, as opposed to individual computers failing
+func (s *runStats) failed() bool {
+	return s.Fatal != ""
+}
+
+// The exit code for the outcome of the run
+func (s *runStats) exitCode() int {
+	switch {
+	case s.failed():
+		return exitFatal
+	case s.Tripped != "":
+		return exitTripped
+	case len(s.Errors) > 0:
+		return exitItemErrors
+	case s.Removed > 0:
+		return exitRemoved
+	}
+	return exitSuccess
+}
+
+// A run needs attention when it failed or a safety limit stopped it from making changes
+func (s *runStats) alerting() bool {
+	return s.failed() || s.Tripped != ""
+}
+
+// Record the end of the run, including an error that stopped it and the stack of a panic, and send the results to
+// the configured outputs
+func finishRun(err error, stack string) {
+	stats.End = clock.Now()
+	countWorkstations()
+	if err != nil {
+		stats.Fatal = redact(err.Error())
+	}
+	finishOpenSpans(stats.Fatal)
+	recordLastRun()
+	if outputFile != "" {
+		if err := writeOutputFile(outputFile); err != nil {
+			writeWarn("Unable to write " + outputFile + ": " + err.Error())
+		}
+	}
+	if outputFormat == "json" {
+		writeJSONResult(os.Stdout)
+	}
+	if simulate {
+		return
+	}
+	if config.SummaryFile != "" {
+		if err := writeSummaryFile(); err != nil {
+			writeWarn("Unable to write the run summary file: " + err.Error())
+		}
+	}
+	if config.History.File != "" {
+		if err := recordHistory(); err != nil {
+			writeWarn("Unable to save the run history: " + err.Error())
+		}
+	}
+	if config.Tracing.Endpoint != "" {
+		if err := exportTraces(); err != nil {
+			writeWarn("Unable to export traces: " + err.Error())
+		}
+	}
+	if config.Metrics.TextfileDirectory != "" {
+		if err := writeMetricsTextfile(); err != nil {
+			writeWarn("Unable to write metrics file: " + err.Error())
+		}
+	}
+	if config.Metrics.Statsd.Address != "" {
+		if err := sendStatsd(); err != nil {
+			writeWarn("Unable to send metrics to statsd: " + err.Error())
+		}
+	}
+	if config.Metrics.Pushgateway != "" {
+		if err := pushMetrics(); err != nil {
+			writeWarn("Unable to push metrics: " + err.Error())
+		}
+	}
+	if config.Email.Host != "" {
+		if err := sendEmailReport(); err != nil {
+			writeWarn("Unable to send email report: " + err.Error())
+		}
+	}
+	if config.Teams.WebhookUrl != "" && shouldNotify(config.Teams.Notify) {
+		if err := sendTeamsNotification(); err != nil {
+			writeWarn("Unable to notify teams: " + err.Error())
+		}
+	}
+	if (config.Slack.WebhookUrl != "" || config.Slack.Token != "") && shouldNotify(config.Slack.Notify) {
+		if err := sendSlackNotification(); err != nil {
+			writeWarn("Unable to notify slack: " + err.Error())
+		}
+	}
+	if config.Webhook.Url != "" && shouldNotify(config.Webhook.Notify) {
+		if err := sendWebhook(); err != nil {
+			writeWarn("Unable to call webhook: " + err.Error())
+		}
+	}
+	if config.PagerDuty.RoutingKey != "" {
+		if err := sendPagerDutyEvent(); err != nil {
+			writeWarn("Unable to send pagerduty event: " + err.Error())
+		}
+	}
+	if config.Opsgenie.ApiKey != "" {
+		if err := sendOpsgenieAlert(); err != nil {
+			writeWarn("Unable to send opsgenie alert: " + err.Error())
+		}
+	}
+	if config.Sentry.Dsn != "" && (stats.failed() || len(stats.Errors) > 0) {
+		if err := sendSentryEvent(stack); err != nil {
+			writeWarn("Unable to report to sentry: " + err.Error())
+		}
+	}
+	if config.ServiceNow.Instance != "" && stats.Removed > 0 {
+		if err := createServiceNowRecord(); err != nil {
+			writeWarn("Unable to create servicenow record: " + err.Error())
+		}
+	}
+	result := currentRunResult()
+	if err := runHook("postRun", config.Hooks.PostRun, hookContext{Result: &result}); err != nil {
+		writeWarn(err.Error())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Send the results of the run to a StatsD server. With dogstatsd enabled the source and tenant are sent as tags,
+// otherwise they become part of the metric name
+func sendStatsd() error {
+	conn, err := net.DialTimeout("udp", config.Metrics.Statsd.Address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	prefix := config.Metrics.Statsd.Prefix
+	if !config.Metrics.Statsd.Dogstatsd && tenantName != "" {
+		prefix += tenantName + "."
+	}
+	send := func(name string, value interface{}, kind string, tags ...string) {
+		line := fmt.Sprintf("%s%s:%v|%s", prefix, name, value, kind)
+		if config.Metrics.Statsd.Dogstatsd {
+			if tenantName != "" {
+				tags = append(tags, "tenant:"+tenantName)
+			}
+			if len(tags) > 0 {
+				line += "|#" + strings.Join(tags, ",")
+			}
+		}
+		conn.Write([]byte(line))
+	}
+
+	sources := []string{}
+	for source := range stats.Sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		if config.Metrics.Statsd.Dogstatsd {
+			send("source.computers", stats.Sources[source], "g", "source:"+source)
+		} else {
+			send("source."+source+".computers", stats.Sources[source], "g")
+		}
+	}
+
+	errors := len(stats.Errors)
+	if stats.failed() {
+		errors++
+	}
+	send("orphans", stats.Orphans, "c")
+	send("exempt", stats.Exempt, "c")
+	send("removed", stats.Removed, "c")
+	send("remove_failed", stats.RemoveFailed, "c")
+	send("added", stats.Added, "c")
+	send("add_failed", stats.AddFailed, "c")
+	send("errors", errors, "c")
+	if stats.Licenses > 0 {
+		send("workstations", stats.Workstations, "g")
+		send("workstation_licenses", stats.Licenses, "g")
+	}
+	send("run_duration", stats.End.Sub(stats.Start).Milliseconds(), "ms")
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	statusLock sync.Mutex
+	lastRun    *runStats
+	runStarted time.Time
+)
+
+// Record the results of a finished run for the status endpoint
+func recordLastRun() {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+	last := stats
+	last.Sources = map[string]int{}
+	for source, count := range stats.Sources {
+		last.Sources[source] = count
+	}
+	last.Errors = append([]string{}, stats.Errors...)
+	last.RemovedComputers = append([]string{}, stats.RemovedComputers...)
+	last.ExemptComputers = append([]string{}, stats.ExemptComputers...)
+	last.AddedComputers = append([]string{}, stats.AddedComputers...)
+	lastRun = &last
+	runStarted = time.Time{}
+}
+
+// Record that a run has started, so the status shows it as in progress
+func recordRunStarted() {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+	runStarted = stats.Start
+}
+
+// Serve /healthz and /status so monitoring can check the sync is alive and its last run succeeded. The server runs
+// for the life of the process, so between runs it only answers in daemon mode
+func startStatusServer(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		statusLock.Lock()
+		failed := lastRun != nil && lastRun.failed()
+		statusLock.Unlock()
+		//Unhealthy only once a run has failed, a process that hasn't finished its first run yet is still healthy
+		if failed {
+			http.Error(w, "last run failed", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	if config.Approval.Key != "" {
+		mux.HandleFunc("/approval", handleApproval)
+	}
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		statusLock.Lock()
+		defer statusLock.Unlock()
+		status := map[string]interface{}{"version": version, "runId": runID, "tenant": tenantName, "running": !runStarted.IsZero()}
+		if !runStarted.IsZero() {
+			status["runStarted"] = runStarted
+		}
+		if lastRun != nil {
+			lastError := lastRun.Fatal
+			if lastError == "" && len(lastRun.Errors) > 0 {
+				lastError = lastRun.Errors[len(lastRun.Errors)-1]
+			}
+			status["lastRun"] = map[string]interface{}{
+				"start":        lastRun.Start,
+				"end":          lastRun.End,
+				"success":      !lastRun.failed(),
+				"sources":      lastRun.Sources,
+				"orphans":      lastRun.Orphans,
+				"exempt":       lastRun.Exempt,
+				"removed":      lastRun.Removed,
+				"removeFailed": lastRun.RemoveFailed,
+				"added":        lastRun.Added,
+				"addFailed":    lastRun.AddFailed,
+				"errors":       len(lastRun.Errors),
+				"lastError":    lastError,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			writeWarn("Unable to serve status endpoint: " + err.Error())
+		}
+	}()
+}
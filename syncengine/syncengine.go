@@ -0,0 +1,231 @@
+// Package syncengine compares the computers in Polaris with those in the directory, deciding which workstations
+// to keep, remove and add
+package syncengine
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/venutios/polarissync/polarisdb"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// A source of directory computers, such as Active Directory over LDAP or Azure AD through powershell
+type Directory interface {
+	//Pass the name of every computer to emit as it is read, returning how many were found
+	Computers(emit func(name string)) (int, error)
+}
+
+// A directory source that also gives each computer an id that stays the same when it is renamed
+type IdentifiedDirectory interface {
+	Directory
+	IdentifiedComputers(emit func(name string, id string)) (int, error)
+}
+
+// The Polaris database, or something standing in for it such as the API or a fake
+type Database interface {
+	Workstations() ([]string, error)
+	Organizations() ([]polarisdb.Organization, error)
+	DeleteWorkstation(name string) error
+	RenameWorkstation(oldName string, newName string) error
+	AddWorkstation(orgID int, name string) (int64, error)
+	AddToGroup(groupID int, workstationID int64) error
+	Close() error
+}
+
+// The source of the current time, so runs can be replayed at a fixed time
+type Clock interface {
+	Now() time.Time
+}
+
+// The clock of the machine
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Optional changes to names before they are compared, on top of ignoring case and surrounding spaces
+type NameRules struct {
+	//Drop everything from the first dot, so PAC-01.library.org matches PAC-01
+	StripDnsSuffix bool
+	//Also match a name cut to the 15 characters NetBIOS allows with the full name, as Polaris sometimes stores one
+	//and the directory the other
+	NetbiosNames bool
+	//Ignore accents, so a name typed as BIBLIOTHEQUE-01 matches BIBLIOTHÈQUE-01
+	StripDiacritics bool
+}
+
+// Names longer than this are truncated by NetBIOS
+const netbiosLength = 15
+
+// The first 15 characters of a name longer than NetBIOS allows, or an empty string for one that fits
+func netbiosPrefix(name string) string {
+	runes := []rune(name)
+	if len(runes) <= netbiosLength {
+		return ""
+	}
+	return string(runes[:netbiosLength])
+}
+
+// The form of a computer name used to compare sources, so case, stray spaces and the rules don't matter. Accented
+// letters are compared in their composed form, whichever way a system stores them. Case is case folded, which unlike
+// upper casing matches every pair of letters Unicode treats as the same, and the result upper cased the same way in
+// every locale as that is how names are shown and written to Polaris, e.g. ß becomes SS
+func (r NameRules) Normalize(name string) string {
+	name = strings.TrimSpace(name)
+	if r.StripDnsSuffix {
+		if i := strings.Index(name, "."); i >= 0 {
+			name = strings.TrimSpace(name[:i])
+		}
+	}
+	if r.StripDiacritics {
+		name = stripDiacritics(name)
+	}
+	//A caser keeps state, so each call needs its own
+	return cases.Upper(language.Und).String(cases.Fold().String(norm.NFC.String(name)))
+}
+
+// Remove the accents from letters by decomposing them and dropping the combining marks
+func stripDiacritics(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(name) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// A set of normalized names, for matching in constant time
+func (r NameRules) NameSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[r.Normalize(name)] = true
+	}
+	return set
+}
+
+// Compares directory computers with the database as they are read, so the full directory is never held in memory
+type Matcher struct {
+	rules    NameRules
+	database map[string]bool
+	//Database names longer than NetBIOS allows, by their first 15 characters, when rules.NetbiosNames is set
+	truncated map[string][]string
+	//Database computers found in a directory source
+	matched map[string]bool
+	//Directory computers missing from the database, in the order they were found
+	added    []string
+	addedSet map[string]bool
+}
+
+// Start comparing with the computer names in the database, normalized by rules
+func NewMatcher(database []string, rules NameRules) *Matcher {
+	m := &Matcher{rules: rules, database: rules.NameSet(database), truncated: map[string][]string{}, matched: map[string]bool{}, addedSet: map[string]bool{}}
+	if rules.NetbiosNames {
+		for name := range m.database {
+			if prefix := netbiosPrefix(name); prefix != "" {
+				m.truncated[prefix] = append(m.truncated[prefix], name)
+			}
+		}
+	}
+	return m
+}
+
+// The database computers a directory name matches, which are marked as found
+func (m *Matcher) match(name string) []string {
+	if m.database[name] {
+		m.matched[name] = true
+		return []string{name}
+	}
+	if !m.rules.NetbiosNames {
+		return nil
+	}
+	//A full directory name matches the truncated name in the database
+	if prefix := netbiosPrefix(name); prefix != "" && m.database[prefix] {
+		m.matched[prefix] = true
+		return []string{prefix}
+	}
+	//A truncated directory name matches the full names in the database. A name only exactly 15 characters long can
+	//be a truncation, so shorter names never match this way
+	if len([]rune(name)) == netbiosLength {
+		for _, full := range m.truncated[name] {
+			m.matched[full] = true
+		}
+		return m.truncated[name]
+	}
+	return nil
+}
+
+// Compare a computer read from a directory source, returning the normalized names of the database computers it
+// matched, or its own normalized name when it is new. Nothing is returned for a blank name. A computer in more than
+// one source, or read again by a retry, is only counted once
+func (m *Matcher) Add(name string) []string {
+	name = m.rules.Normalize(name)
+	if name == "" {
+		return nil
+	}
+	if matched := m.match(name); len(matched) > 0 {
+		return matched
+	}
+	if !m.addedSet[name] {
+		m.addedSet[name] = true
+		m.added = append(m.added, name)
+	}
+	return []string{name}
+}
+
+// Whether a database computer has been found in a directory source
+func (m *Matcher) Found(name string) bool {
+	return m.matched[m.rules.Normalize(name)]
+}
+
+// The directory computers missing from the database, in the order they were found
+func (m *Matcher) New() []string {
+	return m.added
+}
+
+// Treat a database computer missing from the directory as renamed to a directory computer missing from the
+// database, so the new name is no longer added. Returns false, changing nothing, unless both are missing
+func (m *Matcher) Rename(oldName string, newName string) bool {
+	oldName, newName = m.rules.Normalize(oldName), m.rules.Normalize(newName)
+	if !m.database[oldName] || m.matched[oldName] || !m.addedSet[newName] {
+		return false
+	}
+	delete(m.addedSet, newName)
+	for i, name := range m.added {
+		if name == newName {
+			m.added = append(m.added[:i], m.added[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// The outcome of comparing the database with the directory
+type Comparison struct {
+	Keep     []string
+	Exempt   []string
+	Orphaned []string
+}
+
+// Sort the database computers into those found in the directory, those missing but exempt from removal and the
+// orphans that should be removed, keeping the order of the database
+func (m *Matcher) Compare(database []string, exemptions []string) Comparison {
+	exempt := m.rules.NameSet(exemptions)
+	var c Comparison
+	for _, name := range database {
+		switch {
+		case m.Found(name):
+			c.Keep = append(c.Keep, name)
+		case exempt[m.rules.Normalize(name)]:
+			c.Exempt = append(c.Exempt, name)
+		default:
+			c.Orphaned = append(c.Orphaned, name)
+		}
+	}
+	return c
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7, "uucp": 8, "cron": 9,
+	"authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21,
+	"local6": 22, "local7": 23,
+}
+
+// Syslog severities for the error, warn, info and debug levels
+var syslogSeverities = []int{3, 4, 6, 7}
+
+// Create a sink sending RFC 5424 messages over udp, tcp or tls. Stream connections use octet counting framing
+func newSyslogSink() (*logSink, error) {
+	cfg := config.Logging.Syslog
+	facility, ok := syslogFacilities[strings.ToLower(cfg.Facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %s", cfg.Facility)
+	}
+
+	var dial func() (net.Conn, error)
+	switch network := strings.ToLower(cfg.Network); network {
+	case "udp", "tcp":
+		dial = func() (net.Conn, error) { return net.DialTimeout(network, cfg.Address, 10*time.Second) }
+	case "tls":
+		dial = func() (net.Conn, error) {
+			return tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", cfg.Address, nil)
+		}
+	default:
+		return nil, fmt.Errorf("unknown syslog network %s, use udp, tcp or tls", cfg.Network)
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	stream := strings.ToLower(cfg.Network) != "udp"
+	//When the server was last found to be unreachable, so a long outage doesn't wait on a dial for every message
+	var lost time.Time
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	pid := os.Getpid()
+
+	return &logSink{level: parseLevel(cfg.Level), write: func(level int, msg string, fields logFields) {
+		line := fmt.Sprintf("<%d>1 %s %s polarissync %d - %s %s", facility*8+syslogSeverities[level],
+			time.Now().Format(time.RFC3339Nano), hostname, pid, syslogStructuredData(fields), msg)
+		if stream {
+			line = fmt.Sprintf("%d %s", len(line), line)
+		}
+		if conn != nil {
+			if _, err := conn.Write([]byte(line)); err == nil {
+				return
+			}
+			conn.Close()
+			conn = nil
+		} else if time.Since(lost) < time.Minute {
+			return
+		}
+		//The server restarted or the connection dropped, connect again once and resend. Writes are made under the
+		//log lock, so the error can't be logged and goes to stderr
+		var c net.Conn
+		if c, err = dial(); err == nil {
+			if _, err = c.Write([]byte(line)); err == nil {
+				conn = c
+				return
+			}
+			c.Close()
+		}
+		fmt.Fprintln(os.Stderr, "Unable to send to syslog, messages are dropped until it can be reached again: "+err.Error())
+		lost = time.Now()
+	}, close: func() {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+	}}, nil
+}
+
+// Format the run id and log fields as an RFC 5424 structured data element
+func syslogStructuredData(fields logFields) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	keys := []string{}
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sd := `[polarissync@32473 runId="` + runID + `"`
+	for _, k := range keys {
+		sd += fmt.Sprintf(` %s="%s"`, k, escape.Replace(redact(fmt.Sprint(fields[k]))))
+	}
+	return sd + "]"
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Post the run summary to a Microsoft Teams incoming webhook as an adaptive card, with failures in red. A teams
+// template replaces the card with the message it renders
+func sendTeamsNotification() error {
+	custom, err := readTemplate(config.Teams.Template, config.Teams.TemplateFile)
+	if err != nil {
+		return err
+	}
+	if custom != "" {
+		message, err := renderTemplate("teams", custom)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(message) {
+			return fmt.Errorf("the teams template didn't render valid json")
+		}
+		return postJSON(config.Teams.WebhookUrl, json.RawMessage(message), nil)
+	}
+
+	text := func(s string, extra map[string]interface{}) map[string]interface{} {
+		block := map[string]interface{}{"type": "TextBlock", "text": s, "wrap": true}
+		for k, v := range extra {
+			block[k] = v
+		}
+		return block
+	}
+
+	color := "good"
+	if stats.failed() {
+		color = "attention"
+	} else if len(stats.Errors) > 0 {
+		color = "warning"
+	}
+
+	facts := []map[string]string{{"title": "Run", "value": runID}}
+	sources := []string{}
+	for source := range stats.Sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		facts = append(facts, map[string]string{"title": "From " + source, "value": fmt.Sprint(stats.Sources[source])})
+	}
+	for _, f := range []struct {
+		title string
+		value int
+	}{{"Orphans", stats.Orphans}, {"Exempt", stats.Exempt}, {"Removed", stats.Removed}, {"Added", stats.Added}, {"Errors", len(stats.Errors)}} {
+		facts = append(facts, map[string]string{"title": f.title, "value": fmt.Sprint(f.value)})
+	}
+
+	body := []interface{}{
+		text(summaryTitle(), map[string]interface{}{"size": "Medium", "weight": "Bolder", "color": color}),
+		map[string]interface{}{"type": "FactSet", "facts": facts},
+	}
+	if stats.failed() {
+		body = append(body, text("The run was stopped by an error: "+stats.Fatal, map[string]interface{}{"color": "attention"}))
+	}
+	if stats.LicenseAlert != "" {
+		body = append(body, text("License alert: "+stats.LicenseAlert, map[string]interface{}{"color": "warning"}))
+	}
+	if len(stats.Errors) > 0 {
+		body = append(body, text("- "+strings.Join(stats.Errors, "\n- "), map[string]interface{}{"color": "attention"}))
+	}
+	if len(stats.RemovedComputers) > 0 {
+		body = append(body, text("Removed: "+strings.Join(stats.RemovedComputers, ", "), nil))
+	}
+	if len(stats.AddedComputers) > 0 {
+		body = append(body, text("Added: "+strings.Join(stats.AddedComputers, ", "), nil))
+	}
+
+	return postJSON(config.Teams.WebhookUrl, map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{map[string]interface{}{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+			},
+		}},
+	}, nil)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Values available to the email, teams, webhook and report templates
+type templateData struct {
+	RunId      string
+	Tenant     string
+	Version    string
+	Title      string
+	Summary    string
+	Success    bool
+	ReportOnly bool
+	Duration   time.Duration
+	runStats
+}
+
+func currentTemplateData() templateData {
+	return templateData{RunId: runID, Tenant: tenantName, Version: version, Title: summaryTitle(), Summary: summaryText(),
+		Success: !stats.failed(), ReportOnly: reportOnly, Duration: stats.End.Sub(stats.Start).Round(time.Second), runStats: stats}
+}
+
+// Functions available to every template
+var templateFuncs = map[string]interface{}{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join":  strings.Join,
+	"group": groupComputers,
+}
+
+// The text of a user supplied template, read from the file when one is given, relative to the config file. Empty
+// when neither is set, for the built in layout
+func readTemplate(text string, file string) (string, error) {
+	if file == "" {
+		return text, nil
+	}
+	b, err := os.ReadFile(configRelativePath(file))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Render a text template with the results of the run
+func renderTemplate(name string, text string) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, currentTemplateData()); err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return out.Bytes(), nil
+}
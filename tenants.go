@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Run the sync for every tenant in the config. Each tenant runs as a separate invocation of this program so a
+// failure in one library system can't stop the others or leak state between them. Returns the worst exit code of
+// the tenants
+func runTenants(profile string) int {
+	exe, err := os.Executable()
+	if err != nil {
+		writeError(fmt.Errorf("unable to locate the polarissync executable: %w", err))
+		return exitFatal
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	code := exitSuccess
+	results := map[string]json.RawMessage{}
+
+	runTenant := func(name string) {
+		//Pass along the config file that was found so every tenant reads the same one
+		command := "sync"
+		if reportOnly {
+			command = "report"
+		} else if simulate {
+			command = "simulate"
+		}
+		args := []string{command, "-tenant", name, "-config", viper.ConfigFileUsed()}
+		if profile != "" {
+			args = append(args, "-profile", profile)
+		}
+		//Each tenant writes its own results file, e.g. results-abc.csv
+		if outputFile != "" {
+			ext := filepath.Ext(outputFile)
+			args = append(args, "-output", strings.TrimSuffix(outputFile, ext)+"-"+name+ext)
+		}
+		if outputFormat == "json" {
+			args = append(args, "-output-format", "json")
+		}
+		cmd := exec.Command(exe, args...)
+		cmd.Env = append(os.Environ(), "POLARISSYNC_RUN_ID="+runID+"-"+name)
+		var out, result bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		//Keep the json results apart from the log, they are combined into one document at the end
+		if outputFormat == "json" {
+			cmd.Stdout = &result
+		}
+		err := cmd.Run()
+
+		//Hold the lock while writing so output from parallel tenants isn't interleaved
+		mu.Lock()
+		defer mu.Unlock()
+		if out.Len() > 0 {
+			console := os.Stdout
+			if outputFormat == "json" {
+				console = os.Stderr
+			}
+			fmt.Fprintf(console, "==== %s ====\n%s\n", name, out.Bytes())
+		}
+		if result.Len() > 0 {
+			results[name] = json.RawMessage(result.Bytes())
+		}
+		tenantCode := exitSuccess
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			tenantCode = exitErr.ExitCode()
+		} else if err != nil {
+			tenantCode = exitFatal
+		}
+		if tenantCode == exitFatal || (code != exitFatal && tenantCode > code) {
+			code = tenantCode
+		}
+		if tenantCode == exitFatal {
+			failed++
+			writeWarn(fmt.Sprintf("Sync failed for tenant %s: %s", name, err.Error()))
+		} else {
+			writeInfo(fmt.Sprintf("Sync completed for tenant %s with exit code %d", name, tenantCode))
+		}
+	}
+
+	for _, t := range config.Tenants {
+		if t.Name == "" {
+			writeError(fmt.Errorf("every tenant in the config file needs a name"))
+			return exitFatal
+		}
+	}
+
+	for _, t := range config.Tenants {
+		writeInfo("Starting sync for tenant " + t.Name)
+		if config.TenantsParallel {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				runTenant(name)
+			}(t.Name)
+		} else {
+			runTenant(t.Name)
+		}
+	}
+	wg.Wait()
+
+	if outputFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]interface{}{"runId": runID, "tenants": results})
+	}
+
+	if failed > 0 {
+		writeWarn(fmt.Sprintf("%d of %d tenants failed to sync", failed, len(config.Tenants)))
+	}
+	return code
+}
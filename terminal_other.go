@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "os"
+
+// Terminals outside windows understand ANSI colour codes
+func enableVirtualTerminal(f *os.File) bool {
+	return true
+}
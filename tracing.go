@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A timed step of the run, exported to an OpenTelemetry collector over OTLP/HTTP
+type span struct {
+	spanID   string
+	parentID string
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      string
+}
+
+var (
+	traceID   = randomHex(16)
+	spans     []*span
+	openSpans []*span
+	runSpan   *span
+)
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start a span as a child of the innermost open span. Attributes are given as name, value pairs
+func startSpan(name string, attrs ...string) *span {
+	s := &span{spanID: randomHex(8), name: name, start: time.Now(), attrs: map[string]string{}}
+	if len(openSpans) > 0 {
+		s.parentID = openSpans[len(openSpans)-1].spanID
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs[attrs[i]] = attrs[i+1]
+	}
+	spans = append(spans, s)
+	openSpans = append(openSpans, s)
+	return s
+}
+
+// Add a span for work already done outside the run's goroutine, such as a delete by a worker, as a child of the
+// innermost open span
+func recordSpan(name string, start time.Time, end time.Time, err string, attrs ...string) {
+	s := startSpan(name, attrs...)
+	s.start = start
+	if err != "" {
+		s.fail(err)
+	}
+	s.finish()
+	s.end = end
+}
+
+func (s *span) finish() {
+	s.end = time.Now()
+	for i := len(openSpans) - 1; i >= 0; i-- {
+		if openSpans[i] == s {
+			openSpans = append(openSpans[:i], openSpans[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *span) fail(err string) {
+	s.err = redact(err)
+}
+
+// Close any spans left open by an error that stopped the run, marking them as failed
+func finishOpenSpans(err string) {
+	for len(openSpans) > 0 {
+		s := openSpans[len(openSpans)-1]
+		if err != "" && s.err == "" {
+			s.fail(err)
+		}
+		s.finish()
+	}
+}
+
+// Send the spans of the run to the collector using the OTLP json encoding
+func exportTraces() error {
+	type attr struct {
+		Key   string            `json:"key"`
+		Value map[string]string `json:"value"`
+	}
+	attrList := func(m map[string]string) []attr {
+		list := []attr{}
+		for k, v := range m {
+			list = append(list, attr{k, map[string]string{"stringValue": v}})
+		}
+		return list
+	}
+
+	resource := map[string]string{"service.name": "polarissync", "service.version": version, "polarissync.run_id": runID}
+	if tenantName != "" {
+		resource["polarissync.tenant"] = tenantName
+	}
+
+	otlpSpans := []map[string]interface{}{}
+	for _, s := range spans {
+		o := map[string]interface{}{
+			"traceId":           traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": fmt.Sprint(s.start.UnixNano()),
+			"endTimeUnixNano":   fmt.Sprint(s.end.UnixNano()),
+			"attributes":        attrList(s.attrs),
+		}
+		if s.parentID != "" {
+			o["parentSpanId"] = s.parentID
+		}
+		if s.err != "" {
+			o["status"] = map[string]interface{}{"code": 2, "message": s.err}
+		}
+		otlpSpans = append(otlpSpans, o)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   map[string]interface{}{"attributes": attrList(resource)},
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": map[string]string{"name": "polarissync"}, "spans": otlpSpans}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(config.Tracing.Endpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range config.Tracing.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// A run as read back from the history
+type historyRun struct {
+	RunId   string         `json:"runId"`
+	Tenant  string         `json:"tenant,omitempty"`
+	Start   time.Time      `json:"start"`
+	Success bool           `json:"success"`
+	Sources map[string]int `json:"sources"`
+	Orphans int            `json:"orphans"`
+	Removed int            `json:"removed"`
+	Errors  int            `json:"errors"`
+	Anomaly string         `json:"anomaly,omitempty"`
+}
+
+// Totals of the runs started in one week
+type historyWeek struct {
+	Week         string  `json:"week"`
+	Runs         int     `json:"runs"`
+	Failed       int     `json:"failed"`
+	AvgOrphans   float64 `json:"avgOrphans"`
+	Removed      int     `json:"removed"`
+	Workstations int     `json:"workstations"`
+}
+
+func runHistoryCommand(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	weeks := fs.Int("weeks", 12, "number of weeks to report on")
+	fs.StringVar(&outputFormat, "output-format", "text", "print the results as text or json")
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	if config.History.File == "" {
+		fmt.Fprintln(os.Stderr, "There is no run history, set history.file in the config")
+		return exitFatal
+	}
+	//Anomalies only mean something against the runs of the same tenant
+	if *cf.tenant == "" && len(config.Tenants) > 0 {
+		fmt.Fprintln(os.Stderr, "Select the tenant to report on with -tenant")
+		return exitUsage
+	}
+
+	runs, err := loadHistoryRuns(time.Now().AddDate(0, 0, -7**weeks), *cf.tenant)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read the run history: "+redact(err.Error()))
+		return 1
+	}
+	flagAnomalies(runs)
+	summary := weeklyTrends(runs)
+
+	if outputFormat == "json" {
+		anomalies := []historyRun{}
+		for _, r := range runs {
+			if r.Anomaly != "" {
+				anomalies = append(anomalies, r)
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(map[string]interface{}{"weeks": summary, "anomalies": anomalies})
+		return 0
+	}
+
+	fmt.Printf("%-12s %6s %8s %12s %9s %14s\n", "Week", "Runs", "Failed", "Avg orphans", "Removed", "Workstations")
+	for _, w := range summary {
+		fmt.Printf("%-12s %6d %8d %12.1f %9d %14d\n", w.Week, w.Runs, w.Failed, w.AvgOrphans, w.Removed, w.Workstations)
+	}
+	fmt.Println()
+	found := false
+	for _, r := range runs {
+		if r.Anomaly != "" {
+			if !found {
+				fmt.Println("Anomalies:")
+				found = true
+			}
+			fmt.Printf("  %s %s %s\n", r.Start.Local().Format("2006-01-02 15:04"), r.RunId, r.Anomaly)
+		}
+	}
+	if !found {
+		fmt.Println("No anomalies found")
+	}
+	return 0
+}
+
+// Read the runs of a tenant started since the given time, oldest first. Report only runs are left out as they change
+// nothing
+func loadHistoryRuns(since time.Time, tenant string) ([]historyRun, error) {
+	db, err := openHistory()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select run_id, tenant, start_time, success, sources, orphans, removed, errors from runs "+
+		"where start_time >= ? and report_only = 0 and tenant = ? order by start_time",
+		since.UTC().Format(time.RFC3339), tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []historyRun{}
+	for rows.Next() {
+		var r historyRun
+		var start, sources string
+		if err = rows.Scan(&r.RunId, &r.Tenant, &start, &r.Success, &sources, &r.Orphans, &r.Removed, &r.Errors); err != nil {
+			return nil, err
+		}
+		r.Start, _ = time.Parse(time.RFC3339, start)
+		json.Unmarshal([]byte(sources), &r.Sources)
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// Group the runs by the monday of the week they started
+func weeklyTrends(runs []historyRun) []historyWeek {
+	weeks := []historyWeek{}
+	orphans := 0
+	for _, r := range runs {
+		start := r.Start.Local()
+		monday := start.AddDate(0, 0, -(int(start.Weekday())+6)%7).Format("2006-01-02")
+		if len(weeks) == 0 || weeks[len(weeks)-1].Week != monday {
+			weeks = append(weeks, historyWeek{Week: monday})
+			orphans = 0
+		}
+		w := &weeks[len(weeks)-1]
+		w.Runs++
+		if !r.Success {
+			w.Failed++
+		}
+		orphans += r.Orphans
+		w.AvgOrphans = float64(orphans) / float64(w.Runs)
+		w.Removed += r.Removed
+		//The size of the workstations table at the last run of the week
+		if count, ok := r.Sources["polaris"]; ok {
+			w.Workstations = count
+		}
+	}
+	return weeks
+}
+
+// Flag runs where removals or orphans jumped far above the earlier runs, or a source returned far fewer computers
+func flagAnomalies(runs []historyRun) {
+	//Enough earlier runs are needed for a baseline
+	const baseline = 5
+	for i := baseline; i < len(runs); i++ {
+		previous := runs[:i]
+		if spike := spikeOf(previous, runs[i].Removed, func(r historyRun) float64 { return float64(r.Removed) }); spike != "" {
+			runs[i].Anomaly = "removals " + spike
+			continue
+		}
+		if spike := spikeOf(previous, runs[i].Orphans, func(r historyRun) float64 { return float64(r.Orphans) }); spike != "" {
+			runs[i].Anomaly = "orphans " + spike
+			continue
+		}
+		for source, count := range runs[i].Sources {
+			mean, _ := meanStdDev(previous, func(r historyRun) float64 { return float64(r.Sources[source]) })
+			if mean > 0 && float64(count) < mean/2 {
+				runs[i].Anomaly = fmt.Sprintf("%s returned %d computers, usually %.0f", source, count, mean)
+				break
+			}
+		}
+	}
+}
+
+// Describe a value more than three standard deviations above the mean of the earlier runs, ignoring small numbers
+func spikeOf(previous []historyRun, value int, field func(historyRun) float64) string {
+	mean, stddev := meanStdDev(previous, field)
+	if value >= 5 && float64(value) > mean+3*math.Max(stddev, 1) {
+		return fmt.Sprintf("spiked to %d, usually %.1f", value, mean)
+	}
+	return ""
+}
+
+func meanStdDev(runs []historyRun, field func(historyRun) float64) (float64, float64) {
+	sum := 0.0
+	for _, r := range runs {
+		sum += field(r)
+	}
+	mean := sum / float64(len(runs))
+	variance := 0.0
+	for _, r := range runs {
+		variance += (field(r) - mean) * (field(r) - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(runs)))
+}
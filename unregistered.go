@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A directory computer with no Polaris workstation, and the branch it would be registered in
+type unregisteredComputer struct {
+	Computer string   `json:"computer"`
+	Branch   string   `json:"branch"`
+	Sources  []string `json:"sources"`
+}
+
+// List the computers in AD or Azure that have no Polaris workstation, leaving out those a rule ignores, for
+// registering new machines
+func runUnregisteredCommand(args []string) int {
+	fs := flag.NewFlagSet("unregistered", flag.ContinueOnError)
+	cf := addConfigFlags(fs)
+	fs.StringVar(&outputFormat, "output-format", "text", "print the results as text, csv or json")
+	if err := fs.Parse(args); err != nil {
+		return flagsExitCode(err)
+	}
+	if outputFormat != "text" && outputFormat != "csv" && outputFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %s\n", outputFormat)
+		return exitUsage
+	}
+	if err := cf.load(); err != nil {
+		return exitWithError(err)
+	}
+	if *cf.tenant == "" && len(config.Tenants) > 0 {
+		fmt.Fprintln(os.Stderr, "Select the tenant to list with -tenant")
+		return exitUsage
+	}
+
+	if err := loadComputers(); err != nil {
+		return exitWithError(err)
+	}
+	computers := unregisteredComputers()
+
+	switch outputFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(computers)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"computer", "branch", "sources"})
+		for _, c := range computers {
+			w.Write([]string{c.Computer, c.Branch, strings.Join(c.Sources, ";")})
+		}
+		w.Flush()
+	default:
+		fmt.Printf("In the directory but not in Polaris (%d)\n", len(computers))
+		for _, c := range computers {
+			fmt.Printf("  %-20s %-6s %s\n", c.Computer, c.Branch, strings.Join(c.Sources, ", "))
+		}
+	}
+	return 0
+}
+
+// The directory computers a sync would add, in the order they were found, without those ignored by a rule
+func unregisteredComputers() []unregisteredComputer {
+	computers := []unregisteredComputer{}
+	for _, name := range matcher.New() {
+		if policy, _ := nameRule(name); policy == policyIgnore {
+			continue
+		}
+		computers = append(computers, unregisteredComputer{Computer: name, Branch: branchOf(name),
+			Sources: computerSources[normalize(name)]})
+	}
+	return computers
+}
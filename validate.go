@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/spf13/viper"
+)
+
+// Check the config file and test the connection to each enabled system without syncing anything. Every problem
+// found is printed and the return value is used as the exit code, exitUsage when the config itself is wrong and
+// exitFatal when a system can't be reached
+func validate() int {
+	problems := 0
+	report := func(name string, err error) {
+		if err != nil {
+			problems++
+			fmt.Printf("FAIL  %s: %s\n", name, redact(err.Error()))
+		} else {
+			fmt.Printf("OK    %s\n", name)
+		}
+	}
+
+	fmt.Println("Using config file " + viper.ConfigFileUsed())
+
+	for _, key := range unknownConfigKeys() {
+		report("config", fmt.Errorf("unknown key %s", key))
+	}
+	for _, key := range missingConfigKeys() {
+		report("config", fmt.Errorf("missing value for %s", key))
+	}
+	if problems == 0 {
+		report("config", nil)
+	}
+	configProblems := problems
+
+	report("database (read)", checkDatabase(readConnString()))
+	report("database (write)", checkDatabase(writeConnString()))
+	if config.ActiveDirectory.Enabled {
+		report("active directory", checkLDAP())
+	}
+	if config.Azure.Enabled {
+		report("azure", checkAzure())
+	}
+
+	if problems > 0 {
+		fmt.Printf("%d problem(s) found\n", problems)
+		if configProblems > 0 {
+			return exitUsage
+		}
+		return exitFatal
+	}
+	return exitSuccess
+}
+
+// List the settings that have to be filled in for the enabled features
+func missingConfigKeys() []string {
+	missing := []string{}
+	require := func(key string, value string) {
+		if strings.TrimSpace(value) == "" {
+			missing = append(missing, key)
+		}
+	}
+
+	require("database.name", config.Database.Name)
+	if !config.Database.Trusted {
+		require("database.username", config.Database.Username)
+		require("database.password", config.Database.Password)
+	}
+	if (config.ActiveDirectory.Enabled && !config.ActiveDirectory.Trusted) || config.Azure.Enabled {
+		require("activedirectory.username", config.ActiveDirectory.Username)
+		require("activedirectory.password", config.ActiveDirectory.Password)
+	}
+	if config.ActiveDirectory.Enabled && !config.ActiveDirectory.Trusted {
+		require("activedirectory.domain", config.ActiveDirectory.Domain)
+	}
+	if config.ActiveDirectory.Enabled {
+		require("activedirectory.dn", config.ActiveDirectory.Dn)
+	}
+	if config.Azure.Enabled {
+		require("azure.domain", config.Azure.Domain)
+	}
+	return missing
+}
+
+// Connect to the database and make sure the workstations table can be read
+func checkDatabase(connString string) error {
+	conn, err := sql.Open("mssql", connString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err = conn.Ping(); err != nil {
+		return fmt.Errorf("database connection failed: %w", err)
+	}
+
+	var count int
+	if err = conn.QueryRow("select count(*) from Polaris.Workstations").Scan(&count); err != nil {
+		return fmt.Errorf("unable to read workstations: %w", err)
+	}
+	return nil
+}
+
+// Bind to AD and read the search base object
+func checkLDAP() error {
+	l, err := connectLDAP()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	searchReq := ldap.NewSearchRequest(config.ActiveDirectory.Dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"dn"}, nil)
+	if _, err = l.Search(searchReq); err != nil {
+		return fmt.Errorf("unable to read %s: %w", config.ActiveDirectory.Dn, err)
+	}
+	return nil
+}
+
+// Sign in to Azure AD and read the tenant details
+func checkAzure() error {
+	out, err := runAzurePowershell("Get-AzureADTenantDetail | Format-Table -Property DisplayName")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(out), "-----------") {
+		return fmt.Errorf("unable to read tenant details:\n%s", out)
+	}
+	return nil
+}
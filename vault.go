@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type vaultResponse struct {
+	LeaseID       string `json:"lease_id"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool
+	Data          map[string]interface{}
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool
+	}
+}
+
+var (
+	vaultToken string
+	//Secrets already read this run, keyed by api path, so the username and password of a dynamic credential come
+	//from the same lease
+	vaultSecrets = map[string]map[string]interface{}{}
+	//Guards the token and secrets, which the renewals clear when a lease or the token can no longer be renewed
+	vaultLock sync.Mutex
+	//Set when a secret read from vault has stopped being valid, so a daemon resolves the secrets again before the
+	//next run rather than using expired credentials
+	vaultExpired bool
+)
+
+// Read a field of a KV version 2 secret, referenced as vault://mount/path/to/secret#field
+func resolveVaultSecret(ref string) (string, error) {
+	p, field, err := splitVaultReference(strings.TrimPrefix(ref, "vault://"))
+	if err != nil {
+		return "", err
+	}
+	parts := strings.SplitN(p, "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid vault reference %s", ref)
+	}
+	data, err := readVaultSecret(parts[0] + "/data/" + parts[1])
+	if err != nil {
+		return "", err
+	}
+	kv, _ := data["data"].(map[string]interface{})
+	return vaultField(kv, field)
+}
+
+// Read a field of a dynamic database credential, referenced as vault-db://database/creds/role#username
+func resolveVaultDatabaseSecret(ref string) (string, error) {
+	p, field, err := splitVaultReference(strings.TrimPrefix(ref, "vault-db://"))
+	if err != nil {
+		return "", err
+	}
+	data, err := readVaultSecret(p)
+	if err != nil {
+		return "", err
+	}
+	return vaultField(data, field)
+}
+
+func splitVaultReference(ref string) (string, string, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("vault reference %s needs a #field", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+func vaultField(data map[string]interface{}, field string) (string, error) {
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret has no field %s", field)
+	}
+	return value, nil
+}
+
+func readVaultSecret(path string) (map[string]interface{}, error) {
+	vaultLock.Lock()
+	data, ok := vaultSecrets[path]
+	vaultLock.Unlock()
+	if ok {
+		return data, nil
+	}
+	if err := vaultLogin(); err != nil {
+		return nil, fmt.Errorf("unable to log in to vault: %w", err)
+	}
+
+	var resp vaultResponse
+	if err := vaultRequest("GET", path, nil, &resp); err != nil {
+		return nil, err
+	}
+	//Dynamic credentials are only valid for their lease, keep it alive for as long as this process runs
+	if resp.LeaseID != "" && resp.Renewable {
+		leaseID := resp.LeaseID
+		renewVault("lease "+leaseID, resp.LeaseDuration, func() (int, error) {
+			var renewed vaultResponse
+			err := vaultRequest("PUT", "sys/leases/renew", map[string]string{"lease_id": leaseID}, &renewed)
+			return renewed.LeaseDuration, err
+		}, func() {
+			delete(vaultSecrets, path)
+		})
+	}
+	vaultLock.Lock()
+	vaultSecrets[path] = resp.Data
+	vaultLock.Unlock()
+	return resp.Data, nil
+}
+
+// Use the configured token, or log in with AppRole, and keep the token renewed
+func vaultLogin() error {
+	if currentVaultToken() != "" {
+		return nil
+	}
+	if config.Vault.Address == "" {
+		config.Vault.Address = os.Getenv("VAULT_ADDR")
+	}
+	if config.Vault.Token == "" {
+		config.Vault.Token = os.Getenv("VAULT_TOKEN")
+	}
+
+	ttl := 0
+	renewable := false
+	if config.Vault.RoleId != "" {
+		var resp vaultResponse
+		login := map[string]string{"role_id": config.Vault.RoleId, "secret_id": config.Vault.SecretId}
+		if err := vaultRequest("POST", "auth/approle/login", login, &resp); err != nil {
+			return err
+		}
+		if resp.Auth == nil {
+			return fmt.Errorf("approle login returned no token")
+		}
+		setVaultToken(resp.Auth.ClientToken)
+		ttl = resp.Auth.LeaseDuration
+		renewable = resp.Auth.Renewable
+	} else if config.Vault.Token != "" {
+		setVaultToken(config.Vault.Token)
+		var resp vaultResponse
+		if err := vaultRequest("GET", "auth/token/lookup-self", nil, &resp); err != nil {
+			setVaultToken("")
+			return err
+		}
+		if t, ok := resp.Data["ttl"].(float64); ok {
+			ttl = int(t)
+		}
+		renewable, _ = resp.Data["renewable"].(bool)
+	} else {
+		return fmt.Errorf("no vault token or approle configured")
+	}
+
+	if renewable {
+		renewVault("token", ttl, func() (int, error) {
+			var resp vaultResponse
+			err := vaultRequest("POST", "auth/token/renew-self", nil, &resp)
+			if err == nil && resp.Auth != nil {
+				return resp.Auth.LeaseDuration, nil
+			}
+			return 0, err
+		}, func() {
+			//Every secret was read with the token, a new one is needed to read them again
+			vaultToken = ""
+			vaultSecrets = map[string]map[string]interface{}{}
+		})
+	}
+	return nil
+}
+
+func currentVaultToken() string {
+	vaultLock.Lock()
+	defer vaultLock.Unlock()
+	return vaultToken
+}
+
+func setVaultToken(token string) {
+	vaultLock.Lock()
+	defer vaultLock.Unlock()
+	vaultToken = token
+}
+
+// Renew a token or lease at half of its remaining time until vault stops extending it. When a renewal fails, or the
+// lease is about to reach its max TTL, expired forgets what it covered and the secrets are marked for resolving again
+func renewVault(name string, ttl int, renew func() (int, error), expired func()) {
+	go func() {
+		for ttl > 0 {
+			time.Sleep(time.Duration(ttl) * time.Second / 2)
+			var err error
+			if ttl, err = renew(); err != nil {
+				writeWarn(fmt.Sprintf("Unable to renew vault %s: %s", name, err.Error()))
+				break
+			}
+			//Vault shortens the lease instead of failing once the max TTL is near
+			if ttl < 60 {
+				writeWarn(fmt.Sprintf("Vault %s has reached its max TTL", name))
+				break
+			}
+		}
+		vaultLock.Lock()
+		defer vaultLock.Unlock()
+		expired()
+		vaultExpired = true
+	}()
+}
+
+// Whether a secret read from vault has expired since the config was loaded, taking the mark off
+func takeVaultExpired() bool {
+	vaultLock.Lock()
+	defer vaultLock.Unlock()
+	expired := vaultExpired
+	vaultExpired = false
+	return expired
+}
+
+func vaultRequest(method string, path string, body interface{}, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(config.Vault.Address, "/")+"/v1/"+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if token := currentVaultToken(); token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	return doJSONRequest(req, result)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Set at build time, e.g.
+// go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%d)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+func versionString() string {
+	return fmt.Sprintf("polarissync %s (commit %s, built %s, %s %s/%s)", version, commit, buildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+func runVersionCommand(args []string) int {
+	fmt.Println(versionString())
+	return 0
+}
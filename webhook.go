@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Send the run results to any http endpoint. The body is rendered from a go template, by default the results as json
+func sendWebhook() error {
+	w := config.Webhook
+	text, err := readTemplate(w.Template, w.TemplateFile)
+	if err != nil {
+		return err
+	}
+	if text == "" {
+		text = "{{json .}}"
+	}
+	body, err := renderTemplate("webhook", text)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(strings.ToUpper(w.Method), w.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
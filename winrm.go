@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/venutios/polarissync/sources/azure"
+)
+
+// Hold back the computers that answer a WinRM query, as the identity the process runs as. A machine that answers
+// under its own name is still running, one answering under another name has had its name reused and needs a person
+// to sort out which workstation is which. Only computers that can't be reached or resolved pass, any other failure,
+// such as access being denied, says nothing about whether the machine is gone. A single Invoke-Command queries
+// checkWorkers computers at a time
+func checkWinRM(names []string) (map[string]string, error) {
+	timeout := config.Checks.Timeout.Milliseconds()
+	hosts := map[string]string{}
+	quoted := []string{}
+	for _, name := range names {
+		host := checkHost(name)
+		hosts[strings.ToLower(host)] = name
+		quoted = append(quoted, azure.Quote(host))
+	}
+	var script strings.Builder
+	fmt.Fprintf(&script, "$options = New-PSSessionOption -OpenTimeout %d -OperationTimeout %d\n", timeout, timeout)
+	fmt.Fprintf(&script, "$hosts = @(%s)\n", strings.Join(quoted, ","))
+	//The DNS host name rather than $env:COMPUTERNAME, the NetBIOS name cut short at 15 characters
+	fmt.Fprintf(&script, "Invoke-Command -ComputerName $hosts -SessionOption $options -ThrottleLimit %d -ErrorAction SilentlyContinue -ErrorVariable failed -ScriptBlock { [System.Net.Dns]::GetHostName() } | ForEach-Object { $_.PSComputerName + \"`t\" + $_ }\n", checkWorkers)
+	fmt.Fprintln(&script, "foreach ($e in $failed) { [string]$e.TargetObject + \"`t`t\" + $e.FullyQualifiedErrorId + \"`t\" + $e.Exception.ErrorCode }")
+
+	cmd := exec.Command("powershell", "-nologo", "-noprofile", "-noninteractive", "-command", "-")
+	cmd.Stdin = strings.NewReader(script.String())
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to run powershell: %w", err)
+	}
+
+	held := map[string]string{}
+	answered := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 4)
+		name, ok := hosts[strings.ToLower(strings.TrimSpace(parts[0]))]
+		if !ok || (len(parts) != 2 && len(parts) != 4) {
+			continue
+		}
+		answered[name] = true
+		switch reported := strings.TrimSpace(parts[1]); {
+		case len(parts) == 4:
+			errorID, code := strings.TrimSpace(parts[2]), strings.TrimSpace(parts[3])
+			if !winrmUnreachable(errorID, code) {
+				held[name] = "the WinRM query failed with " + errorID + ", the machine may still be running"
+			}
+		case normalize(reported) == normalize(strings.SplitN(name, ".", 2)[0]):
+			held[name] = "answers WinRM, the machine is still running"
+		default:
+			held[name] = "answers WinRM as " + reported + ", the name has been reused by another machine"
+		}
+	}
+	//A computer missing from the output wasn't checked, which is no proof it has gone
+	for _, name := range names {
+		if !answered[name] {
+			held[name] = "the WinRM query didn't complete"
+		}
+	}
+	return held, nil
+}
+
+// WinRM error codes meaning the computer couldn't be reached: the network path or name wasn't found, the connection
+// timed out or was refused, or nothing answered on the WinRM ports
+var winrmUnreachableCodes = map[string]bool{
+	"53": true, "67": true, "1225": true, "1460": true, "1722": true, "11001": true,
+	"2150858770": true, "2150859046": true, "2150859193": true,
+}
+
+// Whether a failed Invoke-Command means the computer is unreachable, by its error id or WinRM error code, rather than
+// being a failure such as access denied or Kerberos that a running machine gives too
+func winrmUnreachable(errorID string, code string) bool {
+	for _, prefix := range []string{"ComputerNotFound,", "NetworkPathNotFound,", "WinRMOperationTimeout,"} {
+		if strings.HasPrefix(errorID, prefix) {
+			return true
+		}
+	}
+	return winrmUnreachableCodes[code]
+}
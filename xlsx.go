@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type xlsxSheet struct {
+	name string
+	rows [][]string
+}
+
+// Write an excel workbook with a sheet each for removals, exemptions, the computers in every source and the
+// computers the sources disagree on
+func writeXLSXFile(path string) error {
+	removals := xlsxSheet{"Removals", [][]string{{"Computer", "Decision", "Reason", "Time"}}}
+	exemptions := xlsxSheet{"Exemptions", [][]string{{"Computer", "Reason", "Time"}}}
+	for _, d := range stats.Decisions {
+		switch d.Decision {
+		case "remove", "remove failed", "skip", "defer", "would remove":
+			removals.rows = append(removals.rows, []string{d.Computer, d.Decision, d.Reason, d.Time.Format(time.RFC3339)})
+		case "exempt", "ignore":
+			exemptions.rows = append(exemptions.rows, []string{d.Computer, d.Reason, d.Time.Format(time.RFC3339)})
+		}
+	}
+
+	sourceNames := []string{}
+	for source := range stats.Sources {
+		sourceNames = append(sourceNames, source)
+	}
+	sort.Strings(sourceNames)
+	computers := []string{}
+	for name := range computerSources {
+		computers = append(computers, name)
+	}
+	sort.Strings(computers)
+
+	inventory := xlsxSheet{"Sources", [][]string{append([]string{"Computer"}, sourceNames...)}}
+	discrepancies := xlsxSheet{"Discrepancies", [][]string{{"Computer", "In Polaris", "In directory", "Sources"}}}
+	for _, name := range computers {
+		found := map[string]bool{}
+		for _, source := range computerSources[name] {
+			found[source] = true
+		}
+		row := []string{name}
+		for _, source := range sourceNames {
+			if found[source] {
+				row = append(row, "yes")
+			} else {
+				row = append(row, "")
+			}
+		}
+		inventory.rows = append(inventory.rows, row)
+
+		inDirectory := found["ad"] || found["azure"]
+		if found["polaris"] != inDirectory {
+			discrepancies.rows = append(discrepancies.rows, []string{name, yesNo(found["polaris"]), yesNo(inDirectory),
+				strings.Join(computerSources[name], ", ")})
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = writeXLSX(f, []xlsxSheet{removals, exemptions, inventory, discrepancies}); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// Write the smallest workbook excel accepts, with every cell as an inline string
+func writeXLSX(w io.Writer, sheets []xlsxSheet) error {
+	z := zip.NewWriter(w)
+	file := func(name string, content string) error {
+		fw, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n"+content)
+		return err
+	}
+
+	var types, workbook, rels strings.Builder
+	for i, sheet := range sheets {
+		n := i + 1
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheet.name), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+
+		var data strings.Builder
+		for r, row := range sheet.rows {
+			fmt.Fprintf(&data, `<row r="%d">`, r+1)
+			for c, value := range row {
+				fmt.Fprintf(&data, `<c r="%s%d" t="inlineStr"><is><t>%s</t></is></c>`, xlsxColumn(c), r+1, xmlEscape(value))
+			}
+			data.WriteString(`</row>`)
+		}
+		err := file(fmt.Sprintf("xl/worksheets/sheet%d.xml", n),
+			`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`+data.String()+`</sheetData></worksheet>`)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := file("[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`+
+		types.String()+`</Types>`)
+	if err != nil {
+		return err
+	}
+	err = file("_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>`+
+		`</Relationships>`)
+	if err != nil {
+		return err
+	}
+	err = file("xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" `+
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`+workbook.String()+`</sheets></workbook>`)
+	if err != nil {
+		return err
+	}
+	err = file("xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+		rels.String()+`</Relationships>`)
+	if err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// Column letters for a zero based index, A to Z then AA onwards
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}